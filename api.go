@@ -1,22 +1,49 @@
 package main
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // --- Controllers ---
 
 func GetMonitors(c *gin.Context) {
 	var monitors []Monitor
-	DB.Preload("Schedules").Find(&monitors)
+	DB.Preload("Schedules").Preload("Records").Preload("Backups").Preload("Checks").Find(&monitors)
+	if SchedulerPaused() {
+		for i := range monitors {
+			monitors[i].SchedulerPaused = true
+		}
+	}
+	// Reported only: the stored status is kept for when the window opens
+	now := time.Now()
+	for i := range monitors {
+		if !monitors[i].InWindow(now) {
+			monitors[i].Status = "Inactive"
+		}
+	}
 	c.JSON(http.StatusOK, monitors)
 }
 
@@ -33,6 +60,22 @@ func CreateMonitor(c *gin.Context) {
 	}
 
 	monitor := input.ToMonitor()
+	if err := monitor.ValidateAccount(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := monitor.ValidateRecordFields(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := monitor.ValidateCheckFields(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateBackups(monitor.Backups, monitor.BackupIP, monitor.OriginalIP); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	monitor.CurrentIP = monitor.OriginalIP
 	monitor.Status = "Normal"
 	monitor.LastCheck = time.Now()
@@ -43,30 +86,107 @@ func CreateMonitor(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Schedule cron and target_ip are required"})
 			return
 		}
+		if _, err := parseRevertAfter(s.RevertAfter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		monitor.Schedules = append(monitor.Schedules, Schedule{
-			Cron:     s.Cron,
-			TargetIP: s.TargetIP,
+			Cron:        s.Cron,
+			TargetIP:    s.TargetIP,
+			RevertAfter: s.RevertAfter,
 		})
 	}
+	for _, r := range input.Records {
+		if r.Domain == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Record cf_domain is required"})
+			return
+		}
+	}
 
-	// Fetch Record ID if missing
-	if monitor.CFRecordID == "" && monitor.CFZoneID != "" && monitor.CFDomain != "" {
-		foundID, err := FetchCloudflareRecordID(&monitor)
-		if err == nil && foundID != "" {
-			monitor.CFRecordID = foundID
-		} else {
-			// Warning but allow creation? Or fail?
-			// Let's allow creation but log/return warning if possible.
-			// Ideally we should probably fail or return a warning field.
-			// For now, let's just log it. The user can check status.
-			log.Printf("Warning: Failed to fetch Record ID during creation: %v\n", err)
+	// Missing Record ID is looked up in the background so the response
+	// doesn't wait on Cloudflare
+	resolveID := monitor.CFRecordID == "" && monitor.CFZoneID != "" && monitor.CFDomain != "" && monitor.UpdateMode != UpdateModeLBPool
+	if resolveID {
+		monitor.RecordIDStatus = "pending"
+	}
+
+	if err := DB.Create(&monitor).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create monitor"})
+		return
+	}
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "create", Message: "Monitor created: " + monitor.Name, Actor: actorFromContext(c)})
+	NotifyConfigChange(NotifyMonitorCreated, &monitor, actorFromContext(c), nil)
+	if resolveID {
+		go ResolveRecordID(monitor.ID)
+	}
+
+	// Reload Scheduler
+	StartScheduler()
+
+	c.JSON(http.StatusOK, monitor)
+}
+
+type CloneRequest struct {
+	Name             string `json:"name"`              // Default: "<name> copy"
+	IncludeSchedules bool   `json:"include_schedules"` // Copy the schedules too
+}
+
+// CloneMonitor creates a monitor with another one's config: account, zone,
+// checks, thresholds, backups, records and optionally schedules. Runtime state
+// starts fresh, the record ID is looked up again and the webhook secret isn't
+// copied.
+func CloneMonitor(c *gin.Context) {
+	var req CloneRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var src Monitor
+	if err := DB.Preload("Schedules").Preload("Records").Preload("Backups").Preload("Checks").First(&src, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+		return
+	}
+
+	mc := src.ToConfig()
+	mc.Name = req.Name
+	if mc.Name == "" {
+		mc.Name = cloneName(src.Name)
+	}
+	monitor := mc.ToMonitor()
+	monitor.CFApiToken = src.CFApiToken
+	monitor.CurrentIP = monitor.OriginalIP
+	monitor.Status = "Normal"
+	monitor.LastCheck = time.Now()
+	for _, r := range src.Records {
+		monitor.Records = append(monitor.Records, MonitorRecord{ZoneID: r.ZoneID, RecordID: r.RecordID, Domain: r.Domain})
+	}
+	for _, b := range src.Backups {
+		monitor.Backups = append(monitor.Backups, MonitorBackup{IP: b.IP, Priority: b.Priority})
+	}
+	for _, cc := range src.Checks {
+		monitor.Checks = append(monitor.Checks, MonitorCheck{Type: cc.Type, Target: cc.Target, Port: cc.Port})
+	}
+	if req.IncludeSchedules {
+		for _, s := range src.Schedules {
+			monitor.Schedules = append(monitor.Schedules, Schedule{Cron: s.Cron, TargetIP: s.TargetIP, RevertAfter: s.RevertAfter})
 		}
 	}
 
+	resolveID := monitor.CFZoneID != "" && monitor.CFDomain != "" && monitor.UpdateMode != UpdateModeLBPool
+	if resolveID {
+		monitor.RecordIDStatus = "pending"
+	}
+
 	if err := DB.Create(&monitor).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create monitor"})
 		return
 	}
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "create", Message: "Monitor cloned from " + src.Name + ": " + monitor.Name, Actor: actorFromContext(c)})
+	NotifyConfigChange(NotifyMonitorCreated, &monitor, actorFromContext(c), nil)
+	if resolveID {
+		go ResolveRecordID(monitor.ID)
+	}
 
 	// Reload Scheduler
 	StartScheduler()
@@ -74,14 +194,84 @@ func CreateMonitor(c *gin.Context) {
 	c.JSON(http.StatusOK, monitor)
 }
 
+// cloneName picks "<name> copy", then "<name> copy 2" and so on, skipping
+// names already taken: config seeding matches monitors by name.
+func cloneName(name string) string {
+	candidate := name + " copy"
+	for n := 2; ; n++ {
+		var count int64
+		DB.Unscoped().Model(&Monitor{}).Where("name = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s copy %d", name, n)
+	}
+}
+
+// MonitorUpdateInput is the UpdateMonitor body: a partial MonitorConfig plus
+// the UI's simple single-schedule fields.
+type MonitorUpdateInput struct {
+	MonitorConfig
+	ReplaceSchedules bool   `json:"replace_schedules"` // Replace with 'schedules', even an empty list
+	ScheduleEnabled  *bool  `json:"schedule_enabled"`  // Use pointer to distinguish missing vs false
+	ScheduleHours    int    `json:"schedule_hours"`
+	ScheduleSwitchIP string `json:"schedule_switch_ip"`
+	// Let schedule_enabled overwrite schedules simple mode can't show
+	DiscardSchedules bool `json:"discard_schedules"`
+}
+
+// What an update does to the monitor's schedules
+type scheduleIntent int
+
+const (
+	schedulesKeep    scheduleIntent = iota // Neither sent, e.g. a general settings save
+	schedulesReplace                       // 'replace_schedules', or a non-empty 'schedules' (older clients)
+	schedulesSimple                        // 'schedule_enabled': single daily schedule on/off
+)
+
+func (in *MonitorUpdateInput) scheduleIntent() scheduleIntent {
+	switch {
+	case in.ReplaceSchedules || len(in.Schedules) > 0:
+		return schedulesReplace
+	case in.ScheduleEnabled != nil:
+		return schedulesSimple
+	}
+	return schedulesKeep
+}
+
+// simpleSchedules reports whether schedules fit simple mode, i.e. at most one
+// daily "0 H * * *" switch without revert_after, so schedule_enabled can
+// replace them without losing anything.
+func simpleSchedules(schedules []Schedule) bool {
+	if len(schedules) > 1 {
+		return false
+	}
+	for _, s := range schedules {
+		var hour int
+		if _, err := fmt.Sscanf(s.Cron, "0 %d * * *", &hour); err != nil || s.Cron != fmt.Sprintf("0 %d * * *", hour) || hour > 23 {
+			return false
+		}
+		if s.RevertAfter != "" {
+			return false
+		}
+	}
+	return true
+}
+
 func UpdateMonitor(c *gin.Context) {
 	id := c.Param("id")
-	var input struct {
-		MonitorConfig
-		ScheduleEnabled  *bool  `json:"schedule_enabled"` // Use pointer to distinguish missing vs false
-		ScheduleHours    int    `json:"schedule_hours"`
-		ScheduleSwitchIP string `json:"schedule_switch_ip"`
+	var input MonitorUpdateInput
+	var monitor Monitor
+	if err := DB.First(&monitor, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+		return
 	}
+
+	// Partial update: the body is decoded over the current config, so fields
+	// the client didn't send keep their values instead of resetting to zero.
+	// ToConfig runs on a copy because its notify flags point into it.
+	current := monitor
+	input.MonitorConfig = current.ToConfig()
 	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -91,25 +281,105 @@ func UpdateMonitor(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and Target are required"})
 		return
 	}
-
-	var monitor Monitor
-	if err := DB.First(&monitor, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+	intent := input.scheduleIntent()
+	if intent == schedulesReplace && input.ScheduleEnabled != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "schedules and schedule_enabled cannot be combined"})
 		return
 	}
+	if intent == schedulesSimple && !input.DiscardSchedules {
+		// Simple mode replaces every schedule; don't collapse an advanced setup
+		var existing []Schedule
+		if err := DB.Where("monitor_id = ?", monitor.ID).Find(&existing).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load schedules"})
+			return
+		}
+		if !simpleSchedules(existing) {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Monitor has %d schedule(s) that simple mode would discard; edit them with replace_schedules, or send discard_schedules to overwrite them", len(existing))})
+			return
+		}
+	}
+	for _, s := range input.Schedules {
+		if s.Cron == "" || s.TargetIP == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Schedule cron and target_ip are required"})
+			return
+		}
+		if _, err := parseRevertAfter(s.RevertAfter); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, r := range input.Records {
+		if r.Domain == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Record cf_domain is required"})
+			return
+		}
+	}
+	var checks []MonitorCheck
+	for _, cc := range input.Checks {
+		checks = append(checks, cc.ToCheck())
+	}
 
 	// Update Fields
 	monitor.Name = input.Name
 	monitor.AccountName = input.Account
 	monitor.Target = input.Target
 	monitor.Type = input.Type
+	monitor.Port = input.Port
+	monitor.SourceIP = input.SourceIP
+	monitor.Resolver = input.Resolver
+	monitor.GRPCService = input.GRPCService
+	monitor.GRPCTLS = input.GRPCTLS
+	monitor.InsecureTLS = input.InsecureTLS
+	monitor.ClientCertPath = input.ClientCertPath
+	monitor.ClientKeyPath = input.ClientKeyPath
+	monitor.UserAgent = input.UserAgent
 	monitor.DNSType = input.DNSType
+	monitor.RecordPriority = input.RecordPriority
+	monitor.RecordWeight = input.RecordWeight
+	monitor.RecordPort = input.RecordPort
 	monitor.Interval = input.Interval
 	monitor.Timeout = input.Timeout
 	monitor.Retries = input.Retries
 	monitor.RecoveryRetries = input.RecoveryRetries
+	monitor.MaxPacketLoss = input.MaxPacketLoss
+	monitor.MaxLatencyMs = input.MaxLatencyMs
+	monitor.PingAttempts = input.PingAttempts
+	monitor.PingPackets = input.PingPackets
+	monitor.PingDelay = input.PingDelay
 	monitor.OriginalIP = input.OriginalIP
 	monitor.BackupIP = input.BackupIP
+	monitor.Public = input.Public
+	monitor.CheckMode = input.CheckMode
+	monitor.CheckBackup = input.CheckBackup
+	monitor.FailoverCooldown = input.FailoverCooldown
+	monitor.ExpectedContentType = input.ExpectedContentType
+	monitor.MinResponseBytes = input.MinResponseBytes
+	monitor.PromoteBackups = input.PromoteBackups
+	monitor.UpdateMode = input.UpdateMode
+	monitor.LBAccountID = input.LBAccountID
+	monitor.LBPoolID = input.LBPoolID
+	monitor.WindowDays = input.WindowDays
+	monitor.WindowStart = input.WindowStart
+	monitor.WindowEnd = input.WindowEnd
+	monitor.WindowTimezone = input.WindowTimezone
+	monitor.FailoverAfter = input.FailoverAfter
+	if input.NotifyOnFailover != nil {
+		monitor.NotifyOnFailover = *input.NotifyOnFailover
+	}
+	if input.NotifyOnRecovery != nil {
+		monitor.NotifyOnRecovery = *input.NotifyOnRecovery
+	}
+	if input.NotifyOnScheduled != nil {
+		monitor.NotifyOnScheduled = *input.NotifyOnScheduled
+	}
+
+	// Keep the existing monitor token and webhook secret unless new ones are provided
+	if input.ApiToken != "" {
+		monitor.CFApiToken = input.ApiToken
+	}
+	if input.WebhookSecret != "" {
+		monitor.WebhookSecret = input.WebhookSecret
+	}
 
 	// Handle critical field changes that require re-fetching Record ID
 	shouldFetchID := false
@@ -125,6 +395,7 @@ func UpdateMonitor(c *gin.Context) {
 	// If user explicitly provided RecordID (rarely via UI, but possible via API), use it
 	if input.RecordID != "" {
 		monitor.CFRecordID = input.RecordID
+		monitor.RecordIDStatus = ""
 		shouldFetchID = false
 	} else if shouldFetchID {
 		// Reset ID to force re-fetch if not provided but context changed
@@ -132,46 +403,99 @@ func UpdateMonitor(c *gin.Context) {
 	}
 
 	if shouldFetchID && monitor.CFRecordID == "" {
-		foundID, err := FetchCloudflareRecordID(&monitor)
+		foundID, err := FetchCloudflareRecordID(c.Request.Context(), &monitor)
+		if requestTimedOut(c) {
+			return
+		}
 		if err == nil && foundID != "" {
 			monitor.CFRecordID = foundID
+			monitor.RecordIDStatus = "ok"
 		} else {
+			monitor.RecordIDStatus = "error"
 			log.Printf("Warning: Failed to fetch Record ID during update: %v\n", err)
 		}
 	}
 
 	monitor.ApplyDefaults()
+	if err := monitor.ValidateAccount(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := monitor.ValidateRecordFields(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := monitor.ValidateCheckFields(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := ValidateChecks(checks, monitor.Type); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Validated against the stored backups when the list isn't replaced
+	var backups []MonitorBackup
+	if input.MonitorConfig.Backups != nil {
+		for _, bc := range input.MonitorConfig.Backups {
+			backups = append(backups, bc.ToBackup())
+		}
+	} else {
+		DB.Where("monitor_id = ?", monitor.ID).Find(&backups)
+	}
+	if err := ValidateBackups(backups, monitor.BackupIP, monitor.OriginalIP); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Runtime state may have moved on since the monitor was read (a check can
+	// fail over meanwhile): re-read it under the lock and save config only
+	unlock := lockMonitor(monitor.ID)
+	if err := DB.Select(monitorStateFields).First(&monitor, monitor.ID).Error; err != nil {
+		unlock()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+		return
+	}
+
+	// A config change may fix whatever put the monitor in Error: try again
+	resetError := monitor.Status == "Error"
+	if resetError {
+		serving := monitor
+		serving.Backups = backups
+		monitor.Status = serving.ServingStatus()
+		monitor.DNSFailCount = 0
+		monitor.CircuitOpenUntil = time.Time{}
+	}
 
 	// Transaction to ensure atomicity
 	err := DB.Transaction(func(tx *gorm.DB) error {
 		// Save Monitor updates
-		if err := tx.Save(&monitor).Error; err != nil {
+		if err := tx.Model(&monitor).Select("*").Omit(append(monitorStateFields, clause.Associations)...).Updates(&monitor).Error; err != nil {
 			return err
 		}
+		if resetError {
+			if err := tx.Model(&monitor).Select("Status", "DNSFailCount", "CircuitOpenUntil").Updates(&monitor).Error; err != nil {
+				return err
+			}
+		}
 
-		// Handle Schedule Logic
-		// Priority:
-		// 1. Explicit 'schedules' array in JSON (MonitorConfig.Schedules) -> Overwrite all.
-		// 2. 'schedule_enabled' is present (Simple Mode Update) -> Logic below.
-		// 3. Neither -> Do nothing (preserve existing schedules).
-
-		// Note: We can't easily detect if 'schedules' was explicitly sent as empty list vs missing with standard struct.
-		// But since we are supporting the Simple Mode via side-channel fields, we can rely on ScheduleEnabled pointer.
-
-		if len(input.MonitorConfig.Schedules) > 0 {
-			// Case 1: Explicit schedules provided
-			tx.Where("monitor_id = ?", monitor.ID).Delete(&Schedule{})
+		// Schedules only change when the request says so (see scheduleIntent);
+		// an empty 'schedules' alone never wipes them
+		switch intent {
+		case schedulesReplace:
+			if err := tx.Where("monitor_id = ?", monitor.ID).Delete(&Schedule{}).Error; err != nil {
+				return err
+			}
 			for _, s := range input.MonitorConfig.Schedules {
 				if err := tx.Create(&Schedule{
-					MonitorID: monitor.ID,
-					Cron:      s.Cron,
-					TargetIP:  s.TargetIP,
+					MonitorID:   monitor.ID,
+					Cron:        s.Cron,
+					TargetIP:    s.TargetIP,
+					RevertAfter: s.RevertAfter,
 				}).Error; err != nil {
 					return err
 				}
 			}
-		} else if input.ScheduleEnabled != nil {
-			// Case 2: Simple Mode Update (schedule_enabled is present)
+		case schedulesSimple:
 			if *input.ScheduleEnabled {
 				if input.ScheduleSwitchIP == "" {
 					return fmt.Errorf("schedule_switch_ip is required")
@@ -193,10 +517,55 @@ func UpdateMonitor(c *gin.Context) {
 				// Disabled: Clear all schedules
 				tx.Where("monitor_id = ?", monitor.ID).Delete(&Schedule{})
 			}
+		case schedulesKeep:
+			// Touch nothing
+		}
+
+		// Extra records: replaced when 'records' is sent (an empty list clears them)
+		if input.MonitorConfig.Records != nil {
+			if err := tx.Where("monitor_id = ?", monitor.ID).Delete(&MonitorRecord{}).Error; err != nil {
+				return err
+			}
+			for _, rc := range input.MonitorConfig.Records {
+				r := rc.ToRecord()
+				r.MonitorID = monitor.ID
+				if err := tx.Create(&r).Error; err != nil {
+					return err
+				}
+				monitor.Records = append(monitor.Records, r)
+			}
+		}
+
+		// Extra backups: same, replaced when 'backups' is sent
+		if input.MonitorConfig.Backups != nil {
+			if err := tx.Where("monitor_id = ?", monitor.ID).Delete(&MonitorBackup{}).Error; err != nil {
+				return err
+			}
+			for _, b := range backups {
+				b.MonitorID = monitor.ID
+				if err := tx.Create(&b).Error; err != nil {
+					return err
+				}
+				monitor.Backups = append(monitor.Backups, b)
+			}
+		}
+
+		// Extra checks: same, replaced when 'checks' is sent
+		if input.MonitorConfig.Checks != nil {
+			if err := tx.Where("monitor_id = ?", monitor.ID).Delete(&MonitorCheck{}).Error; err != nil {
+				return err
+			}
+			for _, mc := range checks {
+				mc.MonitorID = monitor.ID
+				if err := tx.Create(&mc).Error; err != nil {
+					return err
+				}
+				monitor.Checks = append(monitor.Checks, mc)
+			}
 		}
-		// Case 3: Neither present (e.g. General Settings update) -> Touch nothing.
 		return nil
 	})
+	unlock()
 
 	if err != nil {
 		if strings.Contains(err.Error(), "required") {
@@ -207,6 +576,24 @@ func UpdateMonitor(c *gin.Context) {
 		return
 	}
 
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "update", Message: "Monitor updated: " + monitor.Name, Actor: actorFromContext(c)})
+	changes := ConfigChanges(current, monitor)
+	if intent != schedulesKeep {
+		changes = append(changes, "schedules: replaced")
+	}
+	if input.MonitorConfig.Records != nil {
+		changes = append(changes, "records: replaced")
+	}
+	if input.MonitorConfig.Backups != nil {
+		changes = append(changes, "backups: replaced")
+	}
+	if input.MonitorConfig.Checks != nil {
+		changes = append(changes, "checks: replaced")
+	}
+	if len(changes) > 0 {
+		NotifyConfigChange(NotifyMonitorUpdated, &monitor, actorFromContext(c), changes)
+	}
+
 	// Reload Scheduler
 	StartScheduler()
 
@@ -221,129 +608,1417 @@ func RestoreMonitor(c *gin.Context) {
 		return
 	}
 
-	// Force restore to original IP
+	restoreMonitor(&monitor, actorFromContext(c))
+	c.JSON(http.StatusOK, monitor)
+}
+
+// restoreMonitor forces a monitor back to its original IP. Returns whether the DNS update succeeded.
+// State is only changed if the DNS update succeeded. A monitor that is
+// already Normal on its original IP is left alone: no Cloudflare call, no
+// notification.
+func restoreMonitor(monitor *Monitor, actor string) bool {
+	unlock := lockMonitor(monitor.ID)
+	defer unlock()
+	DB.First(monitor, monitor.ID)
+
+	if monitor.Status == "Normal" && monitor.CurrentIP == monitor.OriginalIP {
+		return true
+	}
+
+	oldIP := monitor.CurrentIP
+	if !UpdateCloudflareDNS(monitor, monitor.OriginalIP) {
+		return false
+	}
+
 	monitor.Status = "Normal"
 	monitor.FailCount = 0
 	monitor.SuccCount = 0
+	// A manual switch that worked proves DNS updates are possible again
+	monitor.DNSFailCount = 0
+	monitor.CircuitOpenUntil = time.Time{}
 	monitor.CurrentIP = monitor.OriginalIP
 	monitor.LastCheck = time.Now()
-
-	if UpdateCloudflareDNS(&monitor, monitor.OriginalIP) {
-		SendNotification(fmt.Sprintf("✅ 手动恢复: %s 已切回主 IP %s", monitor.Name, monitor.OriginalIP))
+	if monitor.Notifies(NotifyManualRestore) {
+		SendNotification(NotifyEvent{Kind: NotifyManualRestore, Monitor: monitor.Name, OldIP: oldIP, NewIP: monitor.OriginalIP})
 	}
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "restore", Message: "Manual restore to original IP", OldIP: oldIP, NewIP: monitor.OriginalIP, Actor: actor})
 
-	DB.Save(&monitor)
-	c.JSON(http.StatusOK, monitor)
+	DB.Model(monitor).Select("Status", "FailCount", "SuccCount", "DNSFailCount", "CircuitOpenUntil", "CurrentIP", "LastCheck").Updates(monitor)
+	return true
 }
 
-func DeleteMonitor(c *gin.Context) {
-	id := c.Param("id")
-
-	// Transaction
-	err := DB.Transaction(func(tx *gorm.DB) error {
-		// Delete associated schedules first
-		if err := tx.Where("monitor_id = ?", id).Delete(&Schedule{}).Error; err != nil {
-			return err
-		}
-		if err := tx.Delete(&Monitor{}, id).Error; err != nil {
-			return err
-		}
-		return nil
-	})
+// failoverMonitor forces a monitor onto its backup IP. State is only changed if the DNS update succeeded.
+func failoverMonitor(monitor *Monitor, actor string) bool {
+	unlock := lockMonitor(monitor.ID)
+	defer unlock()
+	DB.First(monitor, monitor.ID)
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete monitor"})
-		return
+	oldIP := monitor.CurrentIP
+	if !UpdateCloudflareDNS(monitor, monitor.BackupIP) {
+		return false
 	}
 
-	// Reload Scheduler
-	StartScheduler()
+	monitor.Status = "Down"
+	monitor.FailCount = 0
+	monitor.SuccCount = 0
+	monitor.DNSFailCount = 0
+	monitor.CircuitOpenUntil = time.Time{}
+	monitor.CurrentIP = monitor.BackupIP
+	monitor.LastCheck = time.Now()
+	monitor.FailoverAt = monitor.LastCheck
+	cancelScheduledRevert(monitor.ID)
+	if monitor.Notifies(NotifyManualFailover) {
+		SendNotification(NotifyEvent{Kind: NotifyManualFailover, Monitor: monitor.Name, OldIP: oldIP, NewIP: monitor.BackupIP})
+	}
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "failover", Message: "Manual failover to backup IP", OldIP: oldIP, NewIP: monitor.BackupIP, Actor: actor})
 
-	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+	DB.Model(monitor).Select("Status", "FailCount", "SuccCount", "DNSFailCount", "CircuitOpenUntil", "CurrentIP", "LastCheck", "FailoverAt").Updates(monitor)
+	return true
 }
 
-// --- Auth ---
-
-type LoginRequest struct {
-	Token string `json:"token"`
-}
+// TriggerMonitor lets an external monitoring system drive failover/recovery.
+// Authenticated by the monitor's webhook secret instead of a user session:
+// either an X-Signature HMAC of the body keyed with the secret, or the secret
+// itself.
+func TriggerMonitor(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-func AuthStatus(c *gin.Context) {
-	// Check if "jwt_secret" is still the default/placeholder
-	needSetup := AppConfig.Server.JwtSecret == "change-this-secret-key-in-production" || AppConfig.Server.JwtSecret == "please-change-this-secret-key-in-production"
+	// Unauthenticated callers get 401 whether or not the monitor exists, so
+	// the endpoint doesn't reveal monitor IDs. The secret is only accepted in
+	// a header: query strings end up in access and proxy logs.
+	signature := c.GetHeader("X-Signature")
+	secret := c.GetHeader("X-Webhook-Secret")
+	if signature == "" && secret == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "X-Signature or X-Webhook-Secret header required"})
+		return
+	}
 
-	authenticated := false
-	tokenString, err := c.Cookie("token")
-	if err == nil && tokenString != "" {
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(AppConfig.Server.JwtSecret), nil
-		})
-		if err == nil && token.Valid {
-			authenticated = true
+	var monitor Monitor
+	found := DB.First(&monitor, c.Param("id")).Error == nil
+	if signature != "" {
+		if !found || monitor.WebhookSecret == "" || !validWebhookSignature(monitor.WebhookSecret, body, signature) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			return
 		}
+	} else if !found || monitor.WebhookSecret == "" || subtle.ConstantTimeCompare([]byte(monitor.WebhookSecret), []byte(secret)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook secret"})
+		return
 	}
 
-	c.JSON(200, gin.H{
-		"code": 200,
-		"data": gin.H{
-			"need_setup":    needSetup,
-			"authenticated": authenticated,
-			"auth_enabled":  AppConfig.Server.AuthEnabled,
-		},
-	})
-}
+	var input struct {
+		Status string `json:"status"` // down, up
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Status != "down" && input.Status != "up" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be down or up"})
+		return
+	}
+	if monitor.Paused {
+		c.JSON(http.StatusConflict, gin.H{"error": "Monitor is paused"})
+		return
+	}
 
-func Login(c *gin.Context) {
-	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(400, gin.H{"code": 400, "msg": "Invalid request"})
+	changed, ok := ApplyExternalStatus(&monitor, input.Status == "up", "webhook:"+c.ClientIP())
+	if !ok {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update DNS"})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"changed": changed, "status": monitor.Status, "current_ip": monitor.CurrentIP})
+}
 
-	// Validate Token
-	// The "password" is effectively the JWT Secret itself in this simplified model,
-	// OR we can add a specific password field.
-	// Based on the user prompt "加JWT 密钥也能设置", it seems they want to use the Secret as the key.
-	// Let's assume the user enters the Secret Key defined in config.yaml as the password.
+// validWebhookSignature checks an X-Signature header: the hex HMAC-SHA256 of
+// the body, optionally prefixed "sha256=" as GitHub sends it.
+func validWebhookSignature(secret string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(got, hmacSHA256(secret, body))
+}
 
-	if req.Token != AppConfig.Server.JwtSecret {
-		c.JSON(401, gin.H{"code": 401, "msg": "Invalid Token"})
+// TestMonitorDNS re-applies the record's current content through the normal
+// update path, proving the credentials can PATCH this record.
+func TestMonitorDNS(c *gin.Context) {
+	var monitor Monitor
+	if err := DB.Preload("Backups").First(&monitor, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
 		return
 	}
 
-	// Generate JWT
-	claims := jwt.MapClaims{
-		"authorized": true,
-		"exp":        time.Now().Add(time.Hour * 24).Unix(),
+	unlock := lockMonitor(monitor.ID)
+	defer unlock()
+
+	// Prefer the live content so the update is a no-op even if the DB drifted
+	content := monitor.CurrentIP
+	if live, err := FetchCloudflareRecordContent(c.Request.Context(), &monitor); err == nil && live != "" {
+		content = live
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(AppConfig.Server.JwtSecret))
-	if err != nil {
-		c.JSON(500, gin.H{"code": 500, "msg": "Failed to generate token"})
+	if requestTimedOut(c) {
 		return
 	}
 
-	// Set Cookie
-	c.SetCookie("token", tokenString, 3600*24, "/", "", false, true)
+	res := UpdateCloudflareDNSResult(&monitor, content)
+	if res.Success && (monitor.Status == "Error" || !monitor.CircuitOpenUntil.IsZero()) {
+		clearMonitorError(&monitor, "DNS test succeeded", actorFromContext(c))
+		DB.Model(&monitor).Select("Status", "DNSFailCount", "CircuitOpenUntil").Updates(&monitor)
+	}
+	c.JSON(http.StatusOK, res)
+}
 
-	c.JSON(200, gin.H{
-		"code":  200,
-		"msg":   "Login successful",
-		"token": tokenString,
+type MonitorDNSRecord struct {
+	DNSRecordInfo
+	CurrentIP string `json:"current_ip"`
+	InSync    bool   `json:"in_sync"` // The live content matches CurrentIP
+}
+
+// GetMonitorDNS reads the monitor's record live from Cloudflare, to confirm
+// CurrentIP matches what is served or spot a manual change.
+func GetMonitorDNS(c *gin.Context) {
+	var monitor Monitor
+	if err := DB.First(&monitor, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+		return
+	}
+	if monitor.UpdateMode == UpdateModeLBPool {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Monitor switches a load balancer pool, not a DNS record"})
+		return
+	}
+	if monitor.CFZoneID == "" || monitor.CFRecordID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Monitor has no Cloudflare zone or record ID"})
+		return
+	}
+
+	record, err := FetchCloudflareRecord(c.Request.Context(), &monitor)
+	if requestTimedOut(c) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch DNS record: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, MonitorDNSRecord{
+		DNSRecordInfo: record,
+		CurrentIP:     monitor.CurrentIP,
+		InSync:        record.Content == monitor.CurrentIP,
+	})
+}
+
+// RefreshMonitorRecordID looks the record ID up again by name and type, for
+// records that were deleted and recreated in the Cloudflare dashboard.
+func RefreshMonitorRecordID(c *gin.Context) {
+	var monitor Monitor
+	if err := DB.First(&monitor, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Monitor not found"})
+		return
+	}
+
+	unlock := lockMonitor(monitor.ID)
+	defer unlock()
+
+	newID, err := FetchCloudflareRecordID(c.Request.Context(), &monitor)
+	if requestTimedOut(c) {
+		return
+	}
+	if errors.Is(err, errRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No " + monitor.DNSType + " record named " + monitor.CFDomain + " in the zone"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch Record ID: " + err.Error()})
+		return
+	}
+
+	oldID := monitor.CFRecordID
+	if newID != oldID || monitor.RecordIDStatus != "ok" {
+		if err := DB.Model(&monitor).Updates(map[string]interface{}{"cf_record_id": newID, "record_id_status": "ok"}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save Record ID"})
+			return
+		}
+	}
+	if newID != oldID {
+		RecordEvent(Event{MonitorID: monitor.ID, Type: "update", Message: "Record ID refreshed: " + oldID + " -> " + newID, Actor: actorFromContext(c)})
+	}
+	c.JSON(http.StatusOK, gin.H{"cf_record_id": newID, "previous_record_id": oldID, "changed": newID != oldID})
+}
+
+type BulkRequest struct {
+	Action string `json:"action"` // delete, pause, resume, restore, failover
+	IDs    []uint `json:"ids"`
+}
+
+type BulkResult struct {
+	ID      uint   `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Checks CheckAllMonitors runs at the same time
+const checkAllParallel = 8
+
+type CheckAllResult struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Up        bool   `json:"up"`
+	Status    string `json:"status"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// CheckAllMonitors checks every monitor that isn't paused or outside its
+// active window right away, as its scheduled check would, and answers once
+// all are done.
+func CheckAllMonitors(c *gin.Context) {
+	if SchedulerPaused() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduler is paused"})
+		return
+	}
+
+	var all []Monitor
+	DB.Where("paused = ?", false).Find(&all)
+	now := time.Now()
+	monitors := make([]Monitor, 0, len(all))
+	for _, m := range all {
+		if m.InWindow(now) {
+			monitors = append(monitors, m)
+		}
+	}
+
+	results := make([]CheckAllResult, len(monitors))
+	sem := make(chan struct{}, checkAllParallel)
+	var wg sync.WaitGroup
+	for i := range monitors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			m := &monitors[i]
+			up := CheckMonitor(m)
+			results[i] = CheckAllResult{ID: m.ID, Name: m.Name, Up: up, Status: m.Status}
+			if !up {
+				results[i].LastError = m.LastError
+			}
+		}()
+	}
+	wg.Wait()
+
+	up := 0
+	for _, r := range results {
+		if r.Up {
+			up++
+		}
+	}
+	RecordEvent(Event{Type: "check_all", Message: fmt.Sprintf("Checked all monitors: %d up, %d down", up, len(results)-up), Actor: actorFromContext(c)})
+	if requestTimedOut(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"checked": len(results), "up": up, "down": len(results) - up, "results": results})
+}
+
+func BulkMonitors(c *gin.Context) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ids are required"})
+		return
+	}
+
+	results := make([]BulkResult, 0, len(req.IDs))
+	actor := actorFromContext(c)
+
+	switch req.Action {
+	case "delete", "pause", "resume":
+		// Loaded for the deletion notifications, as in DeleteMonitor
+		deleted := map[uint]Monitor{}
+		if req.Action == "delete" {
+			var monitors []Monitor
+			DB.Where("id IN ?", req.IDs).Find(&monitors)
+			for _, m := range monitors {
+				deleted[m.ID] = m
+			}
+		}
+
+		// Pure DB operations: run in a single transaction
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			for _, id := range req.IDs {
+				var res *gorm.DB
+				switch req.Action {
+				case "delete":
+					res = tx.Delete(&Monitor{}, id) // Soft delete, see DeleteMonitor
+				case "pause":
+					res = tx.Model(&Monitor{}).Where("id = ?", id).Update("paused", true)
+				case "resume":
+					res = tx.Model(&Monitor{}).Where("id = ?", id).Update("paused", false)
+				}
+				if res.Error != nil {
+					return res.Error
+				}
+				if res.RowsAffected == 0 {
+					results = append(results, BulkResult{ID: id, Error: "Monitor not found"})
+					continue
+				}
+				results = append(results, BulkResult{ID: id, Success: true})
+			}
+			return nil
+		})
+		if err == nil {
+			for _, r := range results {
+				if r.Success {
+					RecordEvent(Event{MonitorID: r.ID, Type: req.Action, Message: "Bulk " + req.Action, Actor: actor})
+					if m, ok := deleted[r.ID]; ok {
+						NotifyConfigChange(NotifyMonitorDeleted, &m, actor, nil)
+					}
+				}
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Bulk " + req.Action + " failed: " + err.Error()})
+			return
+		}
+
+	case "restore", "failover":
+		// DNS operations can't be rolled back, so report each monitor individually
+		for _, id := range req.IDs {
+			var monitor Monitor
+			if err := DB.First(&monitor, id).Error; err != nil {
+				results = append(results, BulkResult{ID: id, Error: "Monitor not found"})
+				continue
+			}
+
+			var ok bool
+			if req.Action == "restore" {
+				ok = restoreMonitor(&monitor, actor)
+			} else {
+				ok = failoverMonitor(&monitor, actor)
+			}
+			if ok {
+				results = append(results, BulkResult{ID: id, Success: true})
+			} else {
+				results = append(results, BulkResult{ID: id, Error: "Failed to update DNS"})
+			}
+		}
+
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported action: " + req.Action})
+		return
+	}
+
+	// Single reload for the whole batch
+	StartScheduler()
+
+	c.JSON(http.StatusOK, gin.H{"action": req.Action, "results": results})
+}
+
+func DeleteMonitor(c *gin.Context) {
+	id := c.Param("id")
+
+	// Loaded for the notification; a missing monitor still answers Deleted
+	var monitor Monitor
+	found := DB.First(&monitor, id).Error == nil
+
+	// Soft delete: schedules, records, checks and history stay so the monitor
+	// can be undeleted until PurgeDeletedMonitors removes it
+	if err := DB.Delete(&Monitor{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete monitor"})
+		return
+	}
+	if monitorID, convErr := strconv.ParseUint(id, 10, 64); convErr == nil {
+		RecordEvent(Event{MonitorID: uint(monitorID), Type: "delete", Message: "Monitor deleted", Actor: actorFromContext(c)})
+	}
+	if found {
+		NotifyConfigChange(NotifyMonitorDeleted, &monitor, actorFromContext(c), nil)
+	}
+
+	// Reload Scheduler
+	StartScheduler()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// GetDeletedMonitors lists soft-deleted monitors, most recently deleted first.
+func GetDeletedMonitors(c *gin.Context) {
+	var monitors []Monitor
+	DB.Unscoped().Preload("Schedules").Preload("Records").Preload("Backups").Preload("Checks").
+		Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&monitors)
+	c.JSON(http.StatusOK, monitors)
+}
+
+// UndeleteMonitor restores a soft-deleted monitor with its schedules,
+// records and checks.
+func UndeleteMonitor(c *gin.Context) {
+	id := c.Param("id")
+	res := DB.Unscoped().Model(&Monitor{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to undelete monitor"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted monitor not found"})
+		return
+	}
+
+	var monitor Monitor
+	DB.Preload("Schedules").Preload("Records").Preload("Backups").Preload("Checks").First(&monitor, id)
+	RecordEvent(Event{MonitorID: monitor.ID, Type: "undelete", Message: "Monitor undeleted: " + monitor.Name, Actor: actorFromContext(c)})
+
+	// Reload Scheduler
+	StartScheduler()
+
+	c.JSON(http.StatusOK, monitor)
+}
+
+// actorFromContext identifies who triggered a manual action, for the event log.
+// Falls back to the client IP when auth is disabled.
+func actorFromContext(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return username
+	}
+	if key := c.GetString("api_key"); key != "" {
+		return "api-key:" + key
+	}
+	return c.ClientIP()
+}
+
+func GetEvents(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	query := DB.Order("id desc").Limit(limit)
+	if monitorID := c.Query("monitor_id"); monitorID != "" {
+		query = query.Where("monitor_id = ?", monitorID)
+	}
+
+	var events []Event
+	query.Find(&events)
+	c.JSON(http.StatusOK, events)
+}
+
+// GetLogs returns recent log lines from memory, oldest first.
+func GetLogs(c *gin.Context) {
+	lines, _ := strconv.Atoi(c.DefaultQuery("lines", "200"))
+	if lines <= 0 || lines > logBufferSize {
+		lines = 200
+	}
+
+	minLevel := slog.LevelDebug
+	if level := c.Query("level"); level != "" {
+		if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "level must be debug, info, warn or error"})
+			return
+		}
+	}
+
+	var monitorID uint
+	if id := c.Query("monitor_id"); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid monitor_id"})
+			return
+		}
+		monitorID = uint(n)
+	}
+
+	c.JSON(http.StatusOK, recentLogs.Recent(lines, minLevel, monitorID))
+}
+
+func GetMonitorChecks(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var checks []CheckResult
+	DB.Where("monitor_id = ?", c.Param("id")).Order("id desc").Limit(limit).Find(&checks)
+	c.JSON(http.StatusOK, checks)
+}
+
+// --- Public Status Page ---
+
+// PublicMonitorStatus is the sanitized monitor view shown to anonymous visitors:
+// no IPs, zones or tokens.
+type PublicMonitorStatus struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`     // up, down, inactive
+	Uptime24h *float64 `json:"uptime_24h"` // Percent of successful checks, null without history
+}
+
+func GetPublicStatus(c *gin.Context) {
+	if !GetConfig().Server.PublicStatusEnabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Public status page is disabled"})
+		return
+	}
+
+	var monitors []Monitor
+	DB.Preload("Backups").Where("public = ?", true).Order("id").Find(&monitors)
+	uptime := UptimeSince(time.Now().Add(-24 * time.Hour))
+
+	now := time.Now()
+	result := make([]PublicMonitorStatus, 0, len(monitors))
+	for _, m := range monitors {
+		s := PublicMonitorStatus{Name: m.Name, Status: "up"}
+		switch {
+		case !m.InWindow(now):
+			s.Status = "inactive"
+		case m.ServingStatus() == "Down":
+			s.Status = "down"
+		}
+		if u, ok := uptime[m.ID]; ok {
+			s.Uptime24h = &u
+		}
+		result = append(result, s)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// --- Scheduler ---
+
+func GetSchedulerStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"paused": SchedulerPaused()})
+}
+
+func PauseScheduler(c *gin.Context) {
+	setSchedulerPaused(c, true)
+}
+
+func ResumeScheduler(c *gin.Context) {
+	setSchedulerPaused(c, false)
+}
+
+func setSchedulerPaused(c *gin.Context, paused bool) {
+	if err := SetSchedulerPaused(paused); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduler state: " + err.Error()})
+		return
+	}
+
+	eventType, message := "scheduler_resume", "Scheduler resumed"
+	if paused {
+		eventType, message = "scheduler_pause", "Scheduler paused"
+	}
+	RecordEvent(Event{Type: eventType, Message: message, Actor: actorFromContext(c)})
+
+	c.JSON(http.StatusOK, gin.H{"paused": paused})
+}
+
+// ReseedConfig re-reads the monitors from the config file and syncs them as
+// at startup, then reloads the scheduler.
+func ReseedConfig(c *gin.Context) {
+	monitors, err := ReadMonitorConfigs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read config: " + err.Error()})
+		return
+	}
+
+	created, updated := SyncMonitors(monitors, actorFromContext(c))
+	StartScheduler()
+	slog.Info("Config reseeded", "created", len(created), "updated", len(updated))
+	RecordEvent(Event{Type: "reseed", Message: fmt.Sprintf("Monitors synced from config: %d created, %d updated", len(created), len(updated)), Actor: actorFromContext(c)})
+
+	if created == nil {
+		created = []string{}
+	}
+	if updated == nil {
+		updated = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"created": created, "updated": updated})
+}
+
+// GetRawConfig returns the config file with secrets masked.
+func GetRawConfig(c *gin.Context) {
+	data, err := os.ReadFile(ConfigFile())
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read config: " + err.Error()})
+		return
+	}
+	masked, err := MaskConfigSecrets(data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mask config: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"path": ConfigFile(), "content": string(masked)})
+}
+
+type RawConfigInput struct {
+	Content string `json:"content" binding:"required"`
+}
+
+// UpdateRawConfig validates and writes a new config file, then applies it
+// like a restart would: settings, monitors and the scheduler. Masked secrets
+// keep their stored values.
+func UpdateRawConfig(c *gin.Context) {
+	var input RawConfigInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	current, err := os.ReadFile(ConfigFile())
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read config: " + err.Error()})
+		return
+	}
+	data, err := UnmaskConfigSecrets([]byte(input.Content), current)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config: " + err.Error()})
+		return
+	}
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config: " + err.Error()})
+		return
+	}
+	if msg := configLockout(c, &cfg); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refusing to save config: " + msg})
+		return
+	}
+
+	if err := WriteConfigFile(data); err != nil {
+		slog.Error("Failed to write config", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write config: " + err.Error()})
+		return
+	}
+
+	// Bound at startup (listener, database, router middleware)
+	old := GetConfig()
+	restartRequired := []string{}
+	if cfg.Server.Port != old.Server.Port {
+		restartRequired = append(restartRequired, "server.port")
+	}
+	if cfg.Database.Path != old.Database.Path {
+		restartRequired = append(restartRequired, "database.path")
+	}
+	if cfg.Server.AccessLog != old.Server.AccessLog || !slices.Equal(cfg.Server.AccessLogSkipPaths, old.Server.AccessLogSkipPaths) {
+		restartRequired = append(restartRequired, "server.access_log")
+	}
+	if !slices.Equal(cfg.Server.TrustedProxies, old.Server.TrustedProxies) {
+		restartRequired = append(restartRequired, "server.trusted_proxies")
+	}
+	cfg.Server.Port = old.Server.Port
+	cfg.Database.Path = old.Database.Path
+
+	// Setup values go in before the swap, so no reader sees the new config without them
+	applySetupConfig(&cfg)
+	SetConfig(&cfg)
+	InitLogger()
+	created, updated := SyncMonitors(cfg.Monitors, actorFromContext(c))
+	StartScheduler()
+
+	slog.Info("Config file updated", "created", len(created), "updated", len(updated))
+	RecordEvent(Event{Type: "config_update", Message: fmt.Sprintf("Config file updated: %d monitors created, %d updated", len(created), len(updated)), Actor: actorFromContext(c)})
+
+	if created == nil {
+		created = []string{}
+	}
+	if updated == nil {
+		updated = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{"created": created, "updated": updated, "restart_required": restartRequired})
+}
+
+// configLockout explains why applying cfg would lock admins out, or returns
+// "" when it wouldn't.
+func configLockout(c *gin.Context, cfg *Config) string {
+	if cfg.Server.AuthEnabled {
+		secret := cfg.Server.JwtSecret
+		if secret == "" || isDefaultJwtSecret(secret) {
+			secret = GetGlobalConfig(setupJwtSecretKey) // As ApplySetupConfig would
+		}
+		if secret == "" || isDefaultJwtSecret(secret) {
+			return "auth_enabled needs a jwt_secret that is set and not a placeholder"
+		}
+	} else if cfg.Server.StrictSecurity {
+		return "strict_security would refuse to start with auth_enabled false"
+	}
+	if !cfg.IPAllowed(c.ClientIP()) {
+		return fmt.Sprintf("allowed_cidrs does not include this client (%s)", c.ClientIP())
+	}
+
+	// An API key client must keep its own admin access
+	if name := c.GetString("api_key"); name != "" && cfg.Server.AuthEnabled {
+		var value string
+		for _, k := range GetConfig().Server.ApiKeys {
+			if k.Name == name {
+				value = k.Key
+			}
+		}
+		for _, k := range cfg.Server.ApiKeys {
+			if k.Name == name && k.Key == value && !k.Revoked && normalizeRole(k.Role) == RoleAdmin {
+				return ""
+			}
+		}
+		return fmt.Sprintf("api key %q used for this request would lose admin access", name)
+	}
+	return ""
+}
+
+// GetBackup streams a snapshot of the database as a download.
+func GetBackup(c *gin.Context) {
+	if DB.Dialector.Name() != "sqlite" {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Backup is only supported for SQLite databases"})
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "cfguard-backup-*.db")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup file: " + err.Error()})
+		return
+	}
+	path := tmp.Name()
+	tmp.Close()
+	defer os.Remove(path)
+
+	if err := BackupDatabase(path); err != nil {
+		slog.Error("Database backup failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Backup failed: " + err.Error()})
+		return
+	}
+
+	c.FileAttachment(path, fmt.Sprintf("cfguard-%s.db", time.Now().Format("20060102-150405")))
+}
+
+type Stats struct {
+	Total        int        `json:"total"`
+	Up           int        `json:"up"`
+	Down         int        `json:"down"`
+	Error        int        `json:"error"`
+	Paused       int        `json:"paused"`
+	Inactive     int        `json:"inactive"` // Outside their active window
+	OnBackup     int        `json:"on_backup"`
+	DNSFailing   int        `json:"dns_failing"`
+	LastFailover *time.Time `json:"last_failover"`
+	// Whole scheduler paused via /api/scheduler/pause
+	SchedulerPaused bool `json:"scheduler_paused"`
+}
+
+func GetStats(c *gin.Context) {
+	var monitors []Monitor
+	DB.Preload("Backups").Find(&monitors)
+
+	stats := Stats{Total: len(monitors), SchedulerPaused: SchedulerPaused()}
+	now := time.Now()
+	for _, m := range monitors {
+		m.ApplyDefaults()
+		switch {
+		case m.Paused:
+			stats.Paused++
+		case !m.InWindow(now):
+			stats.Inactive++
+		case m.Status == "Down":
+			stats.Down++
+		case m.Status == "Error":
+			stats.Error++
+		default:
+			stats.Up++
+		}
+		if m.IsBackupIP(m.CurrentIP) {
+			stats.OnBackup++
+		}
+		if m.DNSUpdateFailing() {
+			stats.DNSFailing++
+		}
+	}
+
+	var last Event
+	if err := DB.Where("type = ?", "failover").Order("id desc").First(&last).Error; err == nil {
+		stats.LastFailover = &last.CreatedAt
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// --- Cloudflare Discovery ---
+
+// AccountInfo is a configured Cloudflare account without its credentials.
+type AccountInfo struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`  // DNS provider, e.g. cloudflare
+	AuthMode string `json:"auth_mode"` // token, key
+	Default  bool   `json:"default"`   // Used by monitors without an account
+}
+
+func GetAccounts(c *gin.Context) {
+	cfg := GetConfig()
+	accounts := make([]AccountInfo, 0, len(cfg.Accounts))
+	for i := range cfg.Accounts {
+		acc := &cfg.Accounts[i]
+		accounts = append(accounts, AccountInfo{Name: acc.Name, Provider: acc.ProviderName(), AuthMode: acc.AuthMode(), Default: i == 0})
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+func GetCloudflareZones(c *gin.Context) {
+	acc := GetAccountConfig(c.Query("account"))
+	if acc == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": accountError(c.Query("account")).Error()})
+		return
+	}
+
+	zones, err := ListCloudflareZones(c.Request.Context(), acc)
+	if requestTimedOut(c) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list zones: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, zones)
+}
+
+func GetCloudflareRecords(c *gin.Context) {
+	zoneID := c.Query("zone_id")
+	if zoneID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "zone_id is required"})
+		return
+	}
+	if !validCloudflareID(zoneID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "zone_id must be a 32-character hex Cloudflare ID"})
+		return
+	}
+
+	acc := GetAccountConfig(c.Query("account"))
+	if acc == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": accountError(c.Query("account")).Error()})
+		return
+	}
+
+	records, err := ListCloudflareRecords(c.Request.Context(), acc, zoneID)
+	if requestTimedOut(c) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list records: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, records)
+}
+
+func VerifyCloudflare(c *gin.Context) {
+	acc := GetAccountConfig(c.Query("account"))
+	if acc == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": accountError(c.Query("account")).Error()})
+		return
+	}
+
+	res := VerifyCloudflareAccount(c.Request.Context(), acc)
+	if requestTimedOut(c) {
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// --- Users ---
+
+type UserInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+func GetUsers(c *gin.Context) {
+	var users []User
+	DB.Order("id").Find(&users)
+	c.JSON(http.StatusOK, users)
+}
+
+func CreateUser(c *gin.Context) {
+	var input UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Username == "" || input.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and Password are required"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	user := User{Username: input.Username, PasswordHash: string(hash), Role: normalizeRole(input.Role)}
+	if err := DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create user (username taken?)"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func UpdateUser(c *gin.Context) {
+	id := c.Param("id")
+	var input UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if input.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+		user.PasswordHash = string(hash)
+	}
+	if input.Role != "" {
+		user.Role = normalizeRole(input.Role)
+	}
+
+	if err := DB.Save(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func DeleteUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var user User
+	if err := DB.First(&user, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Username == c.GetString("username") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the current user"})
+		return
+	}
+
+	if err := DB.Delete(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted"})
+}
+
+// --- Auth ---
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Compared against on unknown usernames to keep login timing uniform
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("cfguard"), bcrypt.DefaultCost)
+
+const (
+	RoleAdmin    = "admin"
+	RoleReadonly = "readonly"
+)
+
+// normalizeRole maps missing/unknown roles to admin so tokens issued before
+// roles existed keep working in single-admin deployments.
+func normalizeRole(role string) string {
+	if role == RoleReadonly {
+		return RoleReadonly
+	}
+	return RoleAdmin
+}
+
+func parseJWT(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(GetConfig().Server.JwtSecret), nil
+	})
+}
+
+func sessionTTL() time.Duration {
+	if ttl := GetConfig().Server.SessionTTL; ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
+
+// issueSession signs a JWT valid for the session TTL and sets it as the session cookie.
+func issueSession(c *gin.Context, username, role string) (string, error) {
+	ttl := sessionTTL()
+	claims := jwt.MapClaims{
+		"authorized": true,
+		"sub":        username,
+		"role":       role,
+		"exp":        time.Now().Add(ttl).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(GetConfig().Server.JwtSecret))
+	if err != nil {
+		return "", err
+	}
+
+	setSessionCookie(c, tokenString, int(ttl.Seconds()))
+	return tokenString, nil
+}
+
+// setSessionCookie writes the token cookie with the configured Secure,
+// SameSite and Domain attributes. A negative maxAge deletes it.
+func setSessionCookie(c *gin.Context, value string, maxAge int) {
+	server := GetConfig().Server
+	cfg := server.Cookie
+	secure := strings.HasPrefix(strings.ToLower(server.BaseURL), "https://")
+	if cfg.Secure != nil {
+		secure = *cfg.Secure
+	}
+	switch strings.ToLower(cfg.SameSite) {
+	case "strict":
+		c.SetSameSite(http.SameSiteStrictMode)
+	case "none":
+		c.SetSameSite(http.SameSiteNoneMode)
+	default:
+		c.SetSameSite(http.SameSiteLaxMode)
+	}
+	c.SetCookie("token", value, maxAge, "/", cfg.Domain, secure, true)
+}
+
+// renewSessionIfExpiring re-issues the session cookie once the token has
+// entered the last quarter of its lifetime (sliding sessions).
+func renewSessionIfExpiring(c *gin.Context, token *jwt.Token) {
+	exp, err := token.Claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return
+	}
+	if time.Until(exp.Time) > sessionTTL()/4 {
+		return
+	}
+	username, _ := token.Claims.(jwt.MapClaims)["sub"].(string)
+	if _, err := issueSession(c, username, tokenRole(token)); err != nil {
+		slog.Error("Failed to renew session", "username", username, "error", err)
+	}
+}
+
+func tokenRole(token *jwt.Token) string {
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		role, _ := claims["role"].(string)
+		return normalizeRole(role)
+	}
+	return RoleAdmin
+}
+
+func AuthStatus(c *gin.Context) {
+	// jwt_secret is still a placeholder and POST /api/setup hasn't run
+	needSetup := NeedSetup()
+
+	authenticated := false
+	role := ""
+	username := ""
+	tokenString, err := c.Cookie("token")
+	if err == nil && tokenString != "" {
+		token, err := parseJWT(tokenString)
+		if err == nil && token.Valid {
+			authenticated = true
+			role = tokenRole(token)
+			username, _ = token.Claims.(jwt.MapClaims)["sub"].(string)
+		}
+	}
+	if !GetConfig().Server.AuthEnabled {
+		role = RoleAdmin
+	}
+
+	c.JSON(200, gin.H{
+		"code": 200,
+		"data": gin.H{
+			"need_setup":    needSetup,
+			"authenticated": authenticated,
+			"auth_enabled":  GetConfig().Server.AuthEnabled,
+			"role":          role,
+			"username":      username,
+		},
+	})
+}
+
+func Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"code": 400, "msg": "Invalid request"})
+		return
+	}
+
+	// Validate Credentials
+	var user User
+	if err := DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
+		// Still run bcrypt so response time doesn't reveal whether the user exists
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		slog.Warn("Failed login for unknown user", "username", req.Username, "client_ip", c.ClientIP())
+		c.JSON(401, gin.H{"code": 401, "msg": "Invalid username or password"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		slog.Warn("Failed login", "username", req.Username, "client_ip", c.ClientIP())
+		c.JSON(401, gin.H{"code": 401, "msg": "Invalid username or password"})
+		return
+	}
+	role := normalizeRole(user.Role)
+
+	tokenString, err := issueSession(c, user.Username, role)
+	if err != nil {
+		c.JSON(500, gin.H{"code": 500, "msg": "Failed to generate token"})
+		return
+	}
+	slog.Info("User logged in", "username", user.Username, "client_ip", c.ClientIP())
+
+	c.JSON(200, gin.H{
+		"code":  200,
+		"msg":   "Login successful",
+		"token": tokenString,
+		"role":  role,
+	})
+}
+
+// SetupRequest is the first-run setup input. Account is optional.
+type SetupRequest struct {
+	Username string `json:"username"` // Default: server.admin_username or "admin"
+	Password string `json:"password"`
+	Account  *struct {
+		Name     string `json:"name"` // Default: "default"
+		ApiToken string `json:"api_token"`
+		Email    string `json:"email"`
+		ApiKey   string `json:"api_key"`
+	} `json:"account"`
+}
+
+var setupMu sync.Mutex
+
+// Setup completes the first run: it sets the admin password, replaces the
+// placeholder JWT secret with a random one and optionally saves a first
+// Cloudflare account. It is only open while NeedSetup is true, so it can't
+// be used again to take over the instance.
+func Setup(c *gin.Context) {
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
+	if !NeedSetup() {
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "msg": "Setup already completed"})
+		return
+	}
+
+	var req SetupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"code": 400, "msg": "Invalid request"})
+		return
+	}
+	if len(req.Password) < 8 {
+		c.JSON(400, gin.H{"code": 400, "msg": "Password must be at least 8 characters"})
+		return
+	}
+	username := req.Username
+	if username == "" {
+		username = GetConfig().Server.AdminUsername
+	}
+	if username == "" {
+		username = "admin"
+	}
+
+	var account *AccountConfig
+	if req.Account != nil {
+		account = &AccountConfig{
+			Name:     req.Account.Name,
+			ApiToken: req.Account.ApiToken,
+			Email:    req.Account.Email,
+			ApiKey:   req.Account.ApiKey,
+		}
+		if account.Name == "" {
+			account.Name = "default"
+		}
+		if !account.hasCredentials() {
+			c.JSON(400, gin.H{"code": 400, "msg": "Account requires api_token, or email and api_key"})
+			return
+		}
+		if existing := FindAccount(account.Name); existing != nil && existing.hasCredentials() {
+			c.JSON(409, gin.H{"code": 409, "msg": "Account " + account.Name + " is already configured in config.yaml"})
+			return
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		c.JSON(500, gin.H{"code": 500, "msg": "Failed to generate secret"})
+		return
+	}
+	jwtSecret := hex.EncodeToString(secret)
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(500, gin.H{"code": 500, "msg": "Failed to hash password"})
+		return
+	}
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Where("username = ?", username).First(&user).Error; err == nil {
+			user.PasswordHash = string(hash)
+			user.Role = RoleAdmin
+			if err := tx.Save(&user).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Create(&User{Username: username, PasswordHash: string(hash), Role: RoleAdmin}).Error; err != nil {
+			return err
+		}
+
+		if account != nil {
+			raw, err := json.Marshal(account)
+			if err != nil {
+				return err
+			}
+			if err := tx.Save(&GlobalConfig{Key: setupAccountKey, Value: string(raw)}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&GlobalConfig{Key: setupJwtSecretKey, Value: jwtSecret}).Error; err != nil {
+			return err
+		}
+		return tx.Save(&GlobalConfig{Key: setupCompleteKey, Value: "true"}).Error
+	})
+	if err != nil {
+		slog.Error("Setup failed", "error", err)
+		c.JSON(500, gin.H{"code": 500, "msg": "Failed to save setup"})
+		return
+	}
+
+	UpdateConfig(func(cfg *Config) {
+		cfg.Server.JwtSecret = jwtSecret
+		if account != nil {
+			addSetupAccount(cfg, *account)
+		}
+	})
+	RecordEvent(Event{Type: "setup", Message: "First-run setup completed", Actor: username})
+	slog.Info("First-run setup completed", "username", username)
+
+	tokenString, err := issueSession(c, username, RoleAdmin)
+	if err != nil {
+		c.JSON(500, gin.H{"code": 500, "msg": "Failed to generate token"})
+		return
+	}
+	c.JSON(200, gin.H{
+		"code":  200,
+		"msg":   "Setup complete",
+		"token": tokenString,
+		"role":  RoleAdmin,
 	})
 }
 
+func Logout(c *gin.Context) {
+	setSessionCookie(c, "", -1)
+	c.JSON(200, gin.H{"code": 200, "msg": "Logged out"})
+}
+
+// findAPIKey returns the non-revoked key matching the given value, or nil.
+// Every configured key is compared in constant time.
+func findAPIKey(value string) *APIKeyConfig {
+	var found *APIKeyConfig
+	keys := GetConfig().Server.ApiKeys
+	for i := range keys {
+		k := &keys[i]
+		if k.Key == "" {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(value)) == 1 && !k.Revoked {
+			found = k
+		}
+	}
+	return found
+}
+
+// AllowedNetworks rejects API requests from outside server.allowed_cidrs,
+// except for the routes listed in server.allowed_cidrs_skip_paths.
+func AllowedNetworks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := GetConfig()
+		if cfg.IPAllowed(c.ClientIP()) || slices.Contains(cfg.Server.AllowedCIDRsSkipPaths, c.FullPath()) {
+			c.Next()
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: client IP not in allowed_cidrs"})
+		c.Abort()
+	}
+}
+
+// Monitoring endpoints outside /api, restricted by MetricsNetworks
+var metricsPaths = []string{"/healthz", "/metrics"}
+
+// MetricsNetworks rejects requests for the monitoring endpoints from outside
+// server.metrics_allowed_cidrs. It is separate from allowed_cidrs because
+// probes and scrapers rarely come from the networks API clients use.
+func MetricsNetworks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(metricsPaths, c.Request.URL.Path) || GetConfig().MetricsIPAllowed(c.ClientIP()) {
+			c.Next()
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: client IP not in metrics_allowed_cidrs"})
+		c.Abort()
+	}
+}
+
+// RequestTimeout puts server.request_timeout on each request's context.
+// Handlers pass that context to Cloudflare reads, which are cancelled at the
+// deadline; a handler that ran out of time without answering gets a 504.
+func RequestTimeout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := GetConfig().Server.RequestTimeout
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if !c.Writer.Written() {
+			requestTimedOut(c)
+		}
+	}
+}
+
+// requestTimedOut answers 504 if the request deadline has passed, for
+// handlers to check after slow work instead of carrying on.
+func requestTimedOut(c *gin.Context) bool {
+	if !errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+	c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+	return true
+}
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !AppConfig.Server.AuthEnabled {
+		if !GetConfig().Server.AuthEnabled {
+			c.Set("role", RoleAdmin)
+			c.Next()
+			return
+		}
+
+		// Machine clients: static API key instead of the JWT login flow
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			key := findAPIKey(apiKey)
+			if key == nil {
+				c.JSON(401, gin.H{"code": 401, "msg": "Invalid API Key"})
+				c.Abort()
+				return
+			}
+			c.Set("api_key", key.Name)
+			c.Set("role", normalizeRole(key.Role))
 			c.Next()
 			return
 		}
 
 		tokenString, err := c.Cookie("token")
+		fromCookie := err == nil && tokenString != ""
 		if err != nil {
 			// Try header
 			authHeader := c.GetHeader("Authorization")
@@ -358,19 +2033,35 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(AppConfig.Server.JwtSecret), nil
-		})
-
+		token, err := parseJWT(tokenString)
 		if err != nil || !token.Valid {
 			c.JSON(401, gin.H{"code": 401, "msg": "Invalid Token"})
 			c.Abort()
 			return
 		}
 
+		c.Set("role", tokenRole(token))
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if username, ok := claims["sub"].(string); ok {
+				c.Set("username", username)
+			}
+		}
+		// Bearer clients manage their own tokens; only browser sessions slide
+		if fromCookie && GetConfig().Server.SessionSliding {
+			renewSessionIfExpiring(c, token)
+		}
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects read-only callers. Must run after AuthMiddleware.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("role") != RoleAdmin {
+			c.JSON(403, gin.H{"code": 403, "msg": "Forbidden: admin role required"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
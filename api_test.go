@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createTestMonitor stores a paused A-record monitor on the "default" account.
+func createTestMonitor(t *testing.T) Monitor {
+	t.Helper()
+	m := Monitor{
+		Name:        "web",
+		AccountName: "default",
+		Target:      "1.1.1.1",
+		Type:        "ping",
+		DNSType:     "A",
+		OriginalIP:  "1.1.1.1",
+		BackupIP:    "2.2.2.2",
+		CurrentIP:   "1.1.1.1",
+		Status:      "Normal",
+		Interval:    60,
+		CFZoneID:    "023e105f4ecef8ad9ca31a8372d0c353",
+		CFDomain:    "web.example.com",
+		CFRecordID:  "rec-old",
+		Paused:      true,
+	}
+	m.ApplyDefaults()
+	if err := DB.Create(&m).Error; err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	return m
+}
+
+// serveAPI runs one request through handler mounted at route.
+func serveAPI(method, route, path, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, route, handler)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateMonitorPartialKeepsOmittedFields(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+
+	w := serveAPI("PUT", "/monitors/:id", fmt.Sprintf("/monitors/%d", m.ID), `{"interval": 30}`, UpdateMonitor)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var got Monitor
+	DB.First(&got, m.ID)
+	if got.Interval != 30 {
+		t.Errorf("interval = %d, want 30", got.Interval)
+	}
+	if got.Name != m.Name || got.Target != m.Target || got.BackupIP != m.BackupIP ||
+		got.CFDomain != m.CFDomain || got.CFRecordID != m.CFRecordID {
+		t.Errorf("omitted fields changed: %+v", got)
+	}
+	if got.Status != "Normal" || got.CurrentIP != "1.1.1.1" || !got.Paused {
+		t.Errorf("state changed: status=%s current_ip=%s paused=%v", got.Status, got.CurrentIP, got.Paused)
+	}
+}
+
+func TestUpdateMonitorKeepsConcurrentFailover(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+
+	// The record lookup triggered by the domain change stands in for a slow
+	// request: a check fails the monitor over while it is in flight
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		DB.Model(&Monitor{}).Where("id = ?", m.ID).Updates(map[string]interface{}{
+			"status":     "Down",
+			"current_ip": "2.2.2.2",
+			"fail_count": 3,
+		})
+		fmt.Fprint(w, `{"success": true, "result": [{"id": "rec-new"}]}`)
+	})
+
+	w := serveAPI("PUT", "/monitors/:id", fmt.Sprintf("/monitors/%d", m.ID), `{"cf_domain": "www.example.com"}`, UpdateMonitor)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var got Monitor
+	DB.First(&got, m.ID)
+	if got.CFDomain != "www.example.com" || got.CFRecordID != "rec-new" {
+		t.Errorf("config not saved: domain=%s record_id=%s", got.CFDomain, got.CFRecordID)
+	}
+	if got.Status != "Down" || got.CurrentIP != "2.2.2.2" || got.FailCount != 3 {
+		t.Errorf("failover overwritten: status=%s current_ip=%s fail_count=%d", got.Status, got.CurrentIP, got.FailCount)
+	}
+}
+
+func TestRestoreMonitorFailedUpdateKeepsState(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+	DB.Model(&m).Updates(map[string]interface{}{"status": "Down", "current_ip": "2.2.2.2"})
+
+	calls := 0
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"success": false, "errors": [{"code": 10000, "message": "internal error"}]}`)
+	})
+
+	for i := 1; i <= 2; i++ {
+		if restoreMonitor(&Monitor{ID: m.ID}, "test") {
+			t.Fatalf("attempt %d: restore reported success", i)
+		}
+		var got Monitor
+		DB.First(&got, m.ID)
+		if got.Status != "Down" || got.CurrentIP != "2.2.2.2" {
+			t.Fatalf("attempt %d: status=%s current_ip=%s, want Down on 2.2.2.2", i, got.Status, got.CurrentIP)
+		}
+		// The retry must reach Cloudflare again rather than see a restored monitor
+		if calls < i {
+			t.Fatalf("attempt %d: Cloudflare not called", i)
+		}
+	}
+}
+
+func TestBulkDeleteNotifies(t *testing.T) {
+	setupTestDB(t)
+	notifications := captureNotifications(t)
+	a := createTestMonitor(t)
+	b := createTestMonitor(t)
+	DB.Model(&b).Update("name", "api")
+
+	body := fmt.Sprintf(`{"action": "delete", "ids": [%d, %d, 999]}`, a.ID, b.ID)
+	w := serveAPI("POST", "/monitors/bulk", "/monitors/bulk", body, BulkMonitors)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	got := notifications(3)
+	slices.Sort(got)
+	want := []string{" deleted monitor api", " deleted monitor web"}
+	if len(got) != len(want) || !strings.HasSuffix(got[0], want[0]) || !strings.HasSuffix(got[1], want[1]) {
+		t.Errorf("notifications = %q, want one per deleted monitor", got)
+	}
+}
+
+func TestSimpleSchedules(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedules []Schedule
+		want      bool
+	}{
+		{"none", nil, true},
+		{"one daily", []Schedule{{Cron: "0 3 * * *", TargetIP: "2.2.2.2"}}, true},
+		{"two", []Schedule{{Cron: "0 3 * * *", TargetIP: "2.2.2.2"}, {Cron: "0 9 * * *", TargetIP: "1.1.1.1"}}, false},
+		{"not daily", []Schedule{{Cron: "0 3 * * 1-5", TargetIP: "2.2.2.2"}}, false},
+		{"minute set", []Schedule{{Cron: "30 3 * * *", TargetIP: "2.2.2.2"}}, false},
+		{"hour out of range", []Schedule{{Cron: "0 24 * * *", TargetIP: "2.2.2.2"}}, false},
+		{"revert_after", []Schedule{{Cron: "0 3 * * *", TargetIP: "2.2.2.2", RevertAfter: "1h"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := simpleSchedules(tt.schedules); got != tt.want {
+				t.Errorf("simpleSchedules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateMonitorSimpleModeKeepsAdvancedSchedules(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+	DB.Create(&Schedule{MonitorID: m.ID, Cron: "0 3 * * 1-5", TargetIP: "2.2.2.2"})
+	DB.Create(&Schedule{MonitorID: m.ID, Cron: "0 9 * * 1-5", TargetIP: "1.1.1.1"})
+	path := fmt.Sprintf("/monitors/%d", m.ID)
+	body := `{"schedule_enabled": true, "schedule_hours": 4, "schedule_switch_ip": "2.2.2.2"`
+
+	w := serveAPI("PUT", "/monitors/:id", path, body+`}`, UpdateMonitor)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409: %s", w.Code, w.Body)
+	}
+	var count int64
+	DB.Model(&Schedule{}).Where("monitor_id = ?", m.ID).Count(&count)
+	if count != 2 {
+		t.Fatalf("%d schedules after rejected update, want 2", count)
+	}
+
+	w = serveAPI("PUT", "/monitors/:id", path, body+`, "discard_schedules": true}`, UpdateMonitor)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d with discard_schedules: %s", w.Code, w.Body)
+	}
+	var schedules []Schedule
+	DB.Where("monitor_id = ?", m.ID).Find(&schedules)
+	if len(schedules) != 1 || schedules[0].Cron != "0 4 * * *" || schedules[0].TargetIP != "2.2.2.2" {
+		t.Errorf("schedules = %+v, want one 0 4 * * * to 2.2.2.2", schedules)
+	}
+}
+
+func TestScheduleIntent(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name  string
+		input MonitorUpdateInput
+		want  scheduleIntent
+	}{
+		{"nothing sent", MonitorUpdateInput{}, schedulesKeep},
+		{"empty schedules alone", MonitorUpdateInput{MonitorConfig: MonitorConfig{Schedules: []ScheduleConfig{}}}, schedulesKeep},
+		{"schedules", MonitorUpdateInput{MonitorConfig: MonitorConfig{Schedules: []ScheduleConfig{{Cron: "0 3 * * *", TargetIP: "2.2.2.2"}}}}, schedulesReplace},
+		{"replace with empty list", MonitorUpdateInput{ReplaceSchedules: true}, schedulesReplace},
+		{"replace wins over simple", MonitorUpdateInput{ReplaceSchedules: true, ScheduleEnabled: &enabled}, schedulesReplace},
+		{"simple enabled", MonitorUpdateInput{ScheduleEnabled: &enabled, ScheduleHours: 3}, schedulesSimple},
+		{"simple disabled", MonitorUpdateInput{ScheduleEnabled: &disabled}, schedulesSimple},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.input.scheduleIntent(); got != tt.want {
+				t.Errorf("scheduleIntent() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPublicStatusErrorOnExtraBackup(t *testing.T) {
+	setupTestDB(t)
+	UpdateConfig(func(c *Config) { c.Server.PublicStatusEnabled = true })
+	m := createTestMonitor(t)
+	DB.Create(&MonitorBackup{MonitorID: m.ID, IP: "3.3.3.3", Priority: 1})
+	DB.Model(&m).Updates(map[string]interface{}{"public": true, "paused": false, "status": "Error", "current_ip": "3.3.3.3"})
+
+	w := serveAPI("GET", "/status", "/status", "", GetPublicStatus)
+	var got []PublicMonitorStatus
+	json.Unmarshal(w.Body.Bytes(), &got)
+	if len(got) != 1 || got[0].Status != "down" {
+		t.Errorf("public status = %s, want one monitor down", w.Body)
+	}
+}
+
+func TestTriggerMonitorAuth(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+	DB.Model(&m).Update("webhook_secret", "s3cret")
+	body := `{"status": "down"}`
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"no credentials", fmt.Sprintf("/monitors/%d/trigger", m.ID), "", http.StatusUnauthorized},
+		{"secret in query", fmt.Sprintf("/monitors/%d/trigger?secret=s3cret", m.ID), "", http.StatusUnauthorized},
+		{"wrong secret", fmt.Sprintf("/monitors/%d/trigger", m.ID), "nope", http.StatusUnauthorized},
+		{"unknown monitor without credentials", "/monitors/999/trigger", "", http.StatusUnauthorized},
+		{"unknown monitor", "/monitors/999/trigger", "s3cret", http.StatusUnauthorized},
+		// Authenticated: the test monitor is paused, so nothing is switched
+		{"secret in header", fmt.Sprintf("/monitors/%d/trigger", m.ID), "s3cret", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/monitors/:id/trigger", TriggerMonitor)
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(body))
+			if tt.header != "" {
+				req.Header.Set("X-Webhook-Secret", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestMetricsNetworks(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Server.AllowedCIDRs = []string{"192.0.2.0/24"}
+	cfg.Server.MetricsAllowedCIDRs = []string{"10.0.0.0/8"}
+	if err := cfg.initNetworks(); err != nil {
+		t.Fatal(err)
+	}
+	SetConfig(&cfg)
+
+	r := gin.New()
+	r.Use(MetricsNetworks())
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	r.GET("/healthz", ok)
+	r.GET("/metrics", ok)
+	r.GET("/api/stats", ok)
+
+	tests := []struct {
+		path   string
+		client string
+		want   int
+	}{
+		{"/healthz", "10.1.2.3", http.StatusOK},
+		{"/metrics", "10.1.2.3", http.StatusOK},
+		{"/healthz", "192.0.2.1", http.StatusForbidden}, // allowed_cidrs doesn't apply
+		{"/metrics", "203.0.113.5", http.StatusForbidden},
+		{"/api/stats", "203.0.113.5", http.StatusOK}, // Left to AllowedNetworks
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.client + ":40000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s from %s: status %d, want %d", tt.path, tt.client, w.Code, tt.want)
+		}
+	}
+}
@@ -2,35 +2,104 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 // --- Cloudflare Service ---
 
-var cfClient = &http.Client{
-	Timeout: 15 * time.Second,
+// The per-request deadline comes from cloudflare.timeout, independent of
+// monitor check timeouts
+var cfClient = &http.Client{}
+
+func cloudflareTimeout() time.Duration {
+	timeout := GetConfig().Cloudflare.Timeout
+	if timeout <= 0 {
+		return 15 * time.Second
+	}
+	return time.Duration(timeout) * time.Second
 }
 
+// doCloudflareRequest sends req under the API deadline and returns the
+// status code and the full response body.
+func doCloudflareRequest(req *http.Request) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), cloudflareTimeout())
+	defer cancel()
+
+	resp, err := cfClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, nil
+}
+
+// GetAccountConfig returns the named account, or the first one when name is
+// empty. An unknown name returns nil rather than someone else's credentials.
 func GetAccountConfig(name string) *AccountConfig {
-	for i := range AppConfig.Accounts {
-		if AppConfig.Accounts[i].Name == name {
-			return &AppConfig.Accounts[i]
-		}
+	if name != "" {
+		return FindAccount(name)
 	}
-	// Fallback to first if not found or empty
-	if len(AppConfig.Accounts) > 0 {
-		return &AppConfig.Accounts[0]
+	if accounts := GetConfig().Accounts; len(accounts) > 0 {
+		return &accounts[0]
+	}
+	return nil
+}
+
+// FindAccount returns the account with exactly this name, or nil.
+func FindAccount(name string) *AccountConfig {
+	accounts := GetConfig().Accounts
+	for i := range accounts {
+		if accounts[i].Name == name {
+			return &accounts[i]
+		}
 	}
 	return nil
 }
 
-func newCloudflareRequest(method, url string, body io.Reader, acc *AccountConfig) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, body)
+// accountError explains why GetAccountConfig(name) returned nil.
+func accountError(name string) error {
+	if name == "" {
+		return fmt.Errorf("no Cloudflare account configured")
+	}
+	accounts := GetConfig().Accounts
+	names := make([]string, 0, len(accounts))
+	for _, a := range accounts {
+		names = append(names, a.Name)
+	}
+	return fmt.Errorf("unknown account %q, valid accounts: %s", name, strings.Join(names, ", "))
+}
+
+// GetMonitorAccountConfig resolves the credentials used for a monitor.
+// A monitor-scoped token takes precedence over the named account.
+func GetMonitorAccountConfig(m *Monitor) *AccountConfig {
+	if m.CFApiToken != "" {
+		return &AccountConfig{
+			Name:     m.AccountName,
+			ApiToken: m.CFApiToken,
+		}
+	}
+	return GetAccountConfig(m.AccountName)
+}
+
+// Requests are bound to ctx: appCtx for background work so shutdown cancels
+// calls still in flight, or the API request's context so reads stop at its
+// deadline. DNS updates always use appCtx; a PATCH cut off midway could leave
+// the record and the monitor state disagreeing.
+func newCloudflareRequest(ctx context.Context, method, url string, body io.Reader, acc *AccountConfig) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -46,101 +115,537 @@ func newCloudflareRequest(method, url string, body io.Reader, acc *AccountConfig
 	return req, nil
 }
 
+// DNSUpdateResult carries the outcome of a DNS record update, including
+// the raw provider response so callers can surface the reason for a failure.
+type DNSUpdateResult struct {
+	Success  bool            `json:"success"`
+	Content  string          `json:"content"`
+	Status   int             `json:"status,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	// Per-record outcome, only set when the monitor has extra records
+	Records []DNSRecordResult `json:"records,omitempty"`
+
+	rejected bool // Failed before reaching Cloudflare for a config reason
+}
+
+// Permanent reports whether the failure needs a config or credential fix
+// rather than a retry: missing IDs or account, or Cloudflare rejecting the
+// request (4xx other than timeouts and rate limits).
+func (r DNSUpdateResult) Permanent() bool {
+	if r.Success {
+		return false
+	}
+	return r.rejected || permanentHTTPStatus(r.Status)
+}
+
+// cfRecordNotFoundCode is Cloudflare's "Record does not exist" error
+const cfRecordNotFoundCode = 81044
+
+// recordMissing reports whether the PATCH failed because the record ID no
+// longer exists (404, or the matching Cloudflare error code).
+func (r DNSUpdateResult) recordMissing() bool {
+	if r.Success {
+		return false
+	}
+	if r.Status == http.StatusNotFound {
+		return true
+	}
+	var body struct {
+		Errors []struct {
+			Code int `json:"code"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(r.Response, &body) == nil {
+		for _, e := range body.Errors {
+			if e.Code == cfRecordNotFoundCode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func permanentHTTPStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+type DNSRecordResult struct {
+	Domain   string `json:"domain"`
+	RecordID string `json:"record_id"`
+	Success  bool   `json:"success"`
+	Status   int    `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	rejected bool
+}
+
 func UpdateCloudflareDNS(m *Monitor, targetIP string) bool {
+	return UpdateCloudflareDNSResult(m, targetIP).Success
+}
+
+// UpdateCloudflareDNSResult is UpdateCloudflareDNS with the full result.
+// The failure reason is kept on the monitor (DNSError) until the next success.
+// Extra records are PATCHed along with the primary one; the update only
+// counts as successful when every record was switched, so a partial failure
+// is retried on the next check instead of leaving the records split.
+func UpdateCloudflareDNSResult(m *Monitor, targetIP string) (res DNSUpdateResult) {
+	res.Content = targetIP
+	defer func() {
+		dnsError := res.Error
+		if res.Success {
+			dnsError = ""
+		}
+		if m.ID == 0 || (dnsError == "" && m.DNSError == "") {
+			return
+		}
+		m.DNSError = dnsError
+		updates := map[string]interface{}{"dns_error": dnsError}
+		if dnsError != "" {
+			m.LastError = "dns: " + dnsError
+			m.LastErrorAt = time.Now()
+			updates["last_error"] = m.LastError
+			updates["last_error_at"] = m.LastErrorAt
+		}
+		if err := DB.Model(m).Updates(updates).Error; err != nil {
+			slog.Error("Failed to save DNS error", "monitor_id", m.ID, "error", err)
+		}
+	}()
+
+	if m.UpdateMode == UpdateModeLBPool {
+		res = updateLoadBalancerPool(m, targetIP)
+		return res
+	}
+
 	if m.CFZoneID == "" || targetIP == "" {
-		log.Println("Skipping DNS update: Missing ZoneID or TargetIP")
-		return false
+		slog.Warn("Skipping DNS update: missing ZoneID or TargetIP", "monitor_id", m.ID, "monitor", m.Name)
+		res.Error = "missing zone id or target"
+		res.rejected = true
+		return res
+	}
+
+	acc := GetMonitorAccountConfig(m)
+	if acc == nil {
+		slog.Error("No Cloudflare account configured", "monitor_id", m.ID, "account", m.AccountName)
+		res.Error = "no cloudflare account configured"
+		res.rejected = true
+		return res
+	}
+	provider, err := GetDNSProvider(acc)
+	if err != nil {
+		slog.Error("No DNS provider for account", "monitor_id", m.ID, "account", acc.Name, "error", err)
+		res.Error = err.Error()
+		res.rejected = true
+		return res
 	}
 
 	if m.CFRecordID == "" {
-		log.Println("RecordID missing, attempting to fetch...")
-		newID, err := FetchCloudflareRecordID(m)
+		slog.Info("RecordID missing, attempting to fetch", "monitor_id", m.ID, "domain", m.CFDomain)
+		newID, err := FetchCloudflareRecordID(appCtx, m)
 		if err == nil && newID != "" {
 			m.CFRecordID = newID
+			m.RecordIDStatus = "ok"
 			// Save to DB for future use
-			if err := DB.Model(m).Update("cf_record_id", newID).Error; err != nil {
-				log.Printf("Failed to save new RecordID to DB: %v", err)
+			if err := DB.Model(m).Select("CFRecordID", "RecordIDStatus").Updates(m).Error; err != nil {
+				slog.Error("Failed to save new RecordID to DB", "monitor_id", m.ID, "error", err)
 			}
-			log.Printf("Fetched and saved new Record ID: %s", newID)
+			slog.Info("Fetched and saved new Record ID", "monitor_id", m.ID, "record_id", newID)
 		} else {
-			log.Printf("Failed to fetch Record ID: %v, aborting update.", err)
-			return false
+			slog.Error("Failed to fetch Record ID, aborting update", "monitor_id", m.ID, "error", err)
+			if m.RecordIDStatus != "error" && m.ID != 0 {
+				m.RecordIDStatus = "error"
+				DB.Model(m).Update("record_id_status", "error")
+			}
+			res.Error = fmt.Sprintf("failed to fetch record id: %v", err)
+			res.rejected = recordLookupRejected(err)
+			return res
 		}
 	}
 
-	acc := GetAccountConfig(m.AccountName)
-	if acc == nil {
-		log.Println("No Cloudflare account configured")
-		return false
+	primary := updateRecordRefreshing(m, provider, m.dnsRecord(m.CFZoneID, m.CFRecordID, m.CFDomain), targetIP, func(id string) {
+		m.CFRecordID = id
+		if err := DB.Model(m).Update("cf_record_id", id).Error; err != nil {
+			slog.Error("Failed to save RecordID to DB", "monitor_id", m.ID, "error", err)
+		}
+	})
+	res.Success = primary.Success
+	res.Status = primary.Status
+	res.Response = primary.Response
+	res.Error = primary.Error
+
+	if m.Records == nil && m.ID != 0 {
+		DB.Where("monitor_id = ?", m.ID).Find(&m.Records)
+	}
+	if len(m.Records) == 0 {
+		return res
 	}
 
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", m.CFZoneID, m.CFRecordID)
+	res.Records = append(res.Records, DNSRecordResult{
+		Domain:   m.CFDomain,
+		RecordID: m.CFRecordID,
+		Success:  primary.Success,
+		Status:   primary.Status,
+		Error:    primary.Error,
+		rejected: primary.Permanent(),
+	})
+	for i := range m.Records {
+		res.Records = append(res.Records, updateExtraRecord(m, provider, &m.Records[i], targetIP))
+	}
+
+	var failed []string
+	for _, r := range res.Records {
+		if !r.Success {
+			failed = append(failed, r.Domain+": "+r.Error)
+			if r.rejected || permanentHTTPStatus(r.Status) {
+				res.rejected = true
+			}
+		}
+	}
+	if len(failed) > 0 {
+		res.Success = false
+		res.Error = fmt.Sprintf("%d/%d records failed: %s", len(failed), len(res.Records), strings.Join(failed, "; "))
+	}
+	return res
+}
+
+// updateExtraRecord switches one of the monitor's extra records, looking up
+// (and saving) its record ID on first use.
+func updateExtraRecord(m *Monitor, provider DNSProvider, r *MonitorRecord, targetIP string) DNSRecordResult {
+	out := DNSRecordResult{Domain: r.Domain, RecordID: r.RecordID}
+	zoneID := r.ZoneID
+	if zoneID == "" {
+		zoneID = m.CFZoneID
+	}
+
+	save := func(id string) {
+		r.RecordID = id
+		out.RecordID = id
+		if r.ID != 0 {
+			if err := DB.Model(r).Update("record_id", id).Error; err != nil {
+				slog.Error("Failed to save RecordID to DB", "monitor_id", m.ID, "domain", r.Domain, "error", err)
+			}
+		}
+	}
+
+	if r.RecordID == "" {
+		newID, err := provider.GetRecordID(appCtx, m.dnsRecord(zoneID, "", r.Domain))
+		if err != nil {
+			slog.Error("Failed to fetch Record ID for extra record", "monitor_id", m.ID, "domain", r.Domain, "error", err)
+			out.Error = fmt.Sprintf("failed to fetch record id: %v", err)
+			out.rejected = recordLookupRejected(err)
+			return out
+		}
+		save(newID)
+	}
+
+	upd := updateRecordRefreshing(m, provider, m.dnsRecord(zoneID, r.RecordID, r.Domain), targetIP, save)
+	out.Success = upd.Success
+	out.Status = upd.Status
+	out.Error = upd.Error
+	return out
+}
+
+// updateRecordRefreshing points one of the monitor's records at targetIP.
+// A record deleted and recreated in the dashboard has a stale ID: it is
+// looked up again and the update retried once. If the record is gone for
+// good the ID is cleared, so the next update looks it up. save stores the
+// refreshed or cleared ID.
+func updateRecordRefreshing(m *Monitor, provider DNSProvider, rec DNSRecord, targetIP string, save func(recordID string)) DNSUpdateResult {
+	res := updateMonitorRecord(m, provider, rec, targetIP)
+	if !res.recordMissing() {
+		return res
+	}
+	lookup := rec
+	lookup.RecordID = ""
+	newID, err := provider.GetRecordID(appCtx, lookup)
+	switch {
+	case err == nil && newID != rec.RecordID:
+		slog.Warn("Record ID is stale, retrying with refreshed ID", "monitor_id", m.ID, "domain", rec.Domain, "old_record_id", rec.RecordID, "record_id", newID)
+		save(newID)
+		rec.RecordID = newID
+		res = updateMonitorRecord(m, provider, rec, targetIP)
+	case errors.Is(err, errRecordNotFound):
+		slog.Warn("Record no longer exists, clearing stored ID", "monitor_id", m.ID, "domain", rec.Domain, "record_id", rec.RecordID)
+		save("")
+	}
+	return res
+}
+
+// updateMonitorRecord points one of the monitor's records at targetIP.
+func updateMonitorRecord(m *Monitor, provider DNSProvider, rec DNSRecord, targetIP string) DNSUpdateResult {
+	res := provider.UpdateRecord(appCtx, rec, targetIP)
+	if res.Success {
+		slog.Info("Successfully updated DNS", "monitor_id", m.ID, "monitor", m.Name, "domain", rec.Domain, "event", "dns_update", "old_ip", m.CurrentIP, "new_ip", targetIP)
+	} else {
+		slog.Error("Failed to update DNS", "monitor_id", m.ID, "domain", rec.Domain, "new_ip", targetIP, "status", res.Status, "error", res.Error)
+	}
+	return res
+}
+
+// cloudflareProvider is the DNSProvider for Cloudflare accounts.
+type cloudflareProvider struct {
+	acc *AccountConfig
+}
+
+// UpdateRecord PATCHes the record using its type and MX/SRV fields.
+func (p cloudflareProvider) UpdateRecord(ctx context.Context, rec DNSRecord, content string) (res DNSUpdateResult) {
+	res.Content = content
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", rec.ZoneID, rec.RecordID)
 
 	// Construct payload
-	dnsType := m.DNSType
+	dnsType := rec.Type
 	if dnsType == "" {
 		dnsType = "A"
 	}
 
 	payload := map[string]interface{}{
-		"content": targetIP,
-		"name":    m.CFDomain,
+		"content": content,
+		"name":    rec.Domain,
 		"type":    dnsType,
 		// "proxied": true, // Optional: preserve proxy status
 	}
 
+	// MX/SRV carry extra fields; for these content is the mail/service hostname
+	switch dnsType {
+	case "MX":
+		payload["priority"] = rec.Priority
+	case "SRV":
+		delete(payload, "content")
+		payload["data"] = map[string]interface{}{
+			"priority": rec.Priority,
+			"weight":   rec.Weight,
+			"port":     rec.Port,
+			"target":   content,
+		}
+	}
+
 	jsonPayload, _ := json.Marshal(payload)
 
-	req, err := newCloudflareRequest("PATCH", url, bytes.NewBuffer(jsonPayload), acc)
+	req, err := newCloudflareRequest(ctx, "PATCH", url, bytes.NewBuffer(jsonPayload), p.acc)
 	if err != nil {
-		log.Printf("Failed to create request: %v", err)
-		return false
+		res.Error = err.Error()
+		return res
 	}
 
-	resp, err := cfClient.Do(req)
+	status, body, err := doCloudflareRequest(req)
 	if err != nil {
-		log.Printf("Failed to update DNS: %v", err)
-		return false
+		res.Error = err.Error()
+		return res
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		log.Printf("Successfully updated DNS for %s to %s", m.Name, targetIP)
-		return true
-	} else {
-		// Read body for error details
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("Failed to update DNS, status: %d, body: %s", resp.StatusCode, string(body))
-		return false
+	res.Status = status
+	if json.Valid(body) {
+		res.Response = body
+	}
+
+	if status >= 200 && status < 300 {
+		res.Success = true
+		return res
+	}
+
+	slog.Debug("Cloudflare rejected DNS update", "domain", rec.Domain, "status", status, "body", string(body))
+	res.Error = cloudflareErrorMessage(status, body)
+	return res
+}
+
+func (p cloudflareProvider) GetRecordID(ctx context.Context, rec DNSRecord) (string, error) {
+	return lookupCloudflareRecordID(ctx, p.acc, rec.ZoneID, rec.Domain, rec.Type)
+}
+
+func (p cloudflareProvider) GetRecord(ctx context.Context, rec DNSRecord) (DNSRecordInfo, error) {
+	var record struct {
+		DNSRecordInfo
+		Data struct {
+			Target string `json:"target"`
+		} `json:"data"`
+	}
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", rec.ZoneID, rec.RecordID)
+	if _, err := cloudflareGet(ctx, url, p.acc, &record); err != nil {
+		return DNSRecordInfo{}, err
+	}
+	// SRV content is "weight port target"; compare on the target only
+	if rec.Type == "SRV" {
+		record.Content = record.Data.Target
 	}
+	return record.DNSRecordInfo, nil
 }
 
-func FetchCloudflareRecordID(m *Monitor) (string, error) {
-	accConfig := GetAccountConfig(m.AccountName)
+// updateLoadBalancerPool switches an lb_pool monitor: the pool origin whose
+// address is targetIP is enabled and the origins at the monitor's other IPs
+// are disabled. Origins the monitor doesn't know about are left alone, and
+// every other origin field is sent back unchanged.
+func updateLoadBalancerPool(m *Monitor, targetIP string) (res DNSUpdateResult) {
+	res.Content = targetIP
+	acc := GetMonitorAccountConfig(m)
+	if acc == nil {
+		slog.Error("No Cloudflare account configured", "monitor_id", m.ID, "account", m.AccountName)
+		res.Error = "no cloudflare account configured"
+		res.rejected = true
+		return res
+	}
+	if acc.ProviderName() != "cloudflare" {
+		res.Error = fmt.Sprintf("update_mode lb_pool needs a cloudflare account, %q uses %s", acc.Name, acc.ProviderName())
+		res.rejected = true
+		return res
+	}
+	if m.Backups == nil && m.ID != 0 {
+		DB.Where("monitor_id = ?", m.ID).Find(&m.Backups)
+	}
+
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/accounts/%s/load_balancers/pools/%s", m.LBAccountID, m.LBPoolID)
+	var pool struct {
+		Origins []map[string]interface{} `json:"origins"`
+	}
+	if _, err := cloudflareGet(appCtx, url, acc, &pool); err != nil {
+		slog.Error("Failed to fetch load balancer pool", "monitor_id", m.ID, "pool_id", m.LBPoolID, "error", err)
+		res.Error = "failed to fetch pool: " + err.Error()
+		return res
+	}
+
+	known := append(m.BackupIPs(), m.OriginalIP)
+	found := false
+	for _, o := range pool.Origins {
+		address, _ := o["address"].(string)
+		switch {
+		case address == targetIP:
+			o["enabled"] = true
+			found = true
+		case slices.Contains(known, address):
+			o["enabled"] = false
+		}
+	}
+	if !found {
+		res.Error = fmt.Sprintf("pool %s has no origin with address %s", m.LBPoolID, targetIP)
+		res.rejected = true
+		return res
+	}
+
+	jsonPayload, _ := json.Marshal(map[string]interface{}{"origins": pool.Origins})
+	req, err := newCloudflareRequest(appCtx, "PATCH", url, bytes.NewBuffer(jsonPayload), acc)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	status, body, err := doCloudflareRequest(req)
+	if err != nil {
+		slog.Error("Failed to update load balancer pool", "monitor_id", m.ID, "pool_id", m.LBPoolID, "new_ip", targetIP, "error", err)
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Status = status
+	if json.Valid(body) {
+		res.Response = body
+	}
+	if status >= 200 && status < 300 {
+		slog.Info("Successfully updated load balancer pool", "monitor_id", m.ID, "monitor", m.Name, "pool_id", m.LBPoolID, "event", "dns_update", "old_ip", m.CurrentIP, "new_ip", targetIP)
+		res.Success = true
+		return res
+	}
+
+	slog.Error("Failed to update load balancer pool", "monitor_id", m.ID, "pool_id", m.LBPoolID, "new_ip", targetIP, "status", status, "body", string(body))
+	res.Error = cloudflareErrorMessage(status, body)
+	return res
+}
+
+// cloudflareErrorMessage summarizes a failed API response as "HTTP <status>: <first error>".
+func cloudflareErrorMessage(status int, body []byte) string {
+	var result struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &result); err == nil && len(result.Errors) > 0 {
+		return fmt.Sprintf("HTTP %d: %s (code %d)", status, result.Errors[0].Message, result.Errors[0].Code)
+	}
+	return fmt.Sprintf("HTTP %d: %s", status, strings.TrimSpace(string(body)))
+}
+
+// ResolveRecordID looks up the record ID of a monitor created without one.
+// RecordIDStatus goes from pending to ok or error; on error the next DNS
+// update tries again.
+func ResolveRecordID(id uint) {
+	unlock := lockMonitor(id)
+	defer unlock()
+
+	var m Monitor
+	if err := DB.First(&m, id).Error; err != nil || m.CFRecordID != "" {
+		return
+	}
+
+	newID, err := FetchCloudflareRecordID(appCtx, &m)
+	if err != nil {
+		if appCtx.Err() != nil {
+			return // Shutting down: stays pending, resolved on the first DNS update
+		}
+		slog.Warn("Failed to resolve Record ID", "monitor_id", m.ID, "domain", m.CFDomain, "error", err)
+		m.RecordIDStatus = "error"
+		m.LastError = "dns: failed to fetch record id: " + err.Error()
+		m.LastErrorAt = time.Now()
+		DB.Model(&m).Select("RecordIDStatus", "LastError", "LastErrorAt").Updates(&m)
+		return
+	}
+
+	m.CFRecordID = newID
+	m.RecordIDStatus = "ok"
+	DB.Model(&m).Select("CFRecordID", "RecordIDStatus").Updates(&m)
+	slog.Info("Resolved Record ID", "monitor_id", m.ID, "domain", m.CFDomain, "record_id", newID)
+}
+
+// errRecordNotFound means the zone has no record with the monitor's name and type.
+var errRecordNotFound = errors.New("record not found")
+
+// cloudflareAPIError is a response with success false, kept with its HTTP
+// status so callers can tell a refused request from a transient failure.
+type cloudflareAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e *cloudflareAPIError) Error() string {
+	return "cloudflare api error: " + e.Message
+}
+
+// recordLookupRejected reports whether a failed record ID lookup needs a
+// config fix rather than a retry: there is no such record, or Cloudflare
+// refused the request.
+func recordLookupRejected(err error) bool {
+	var apiErr *cloudflareAPIError
+	return errors.Is(err, errRecordNotFound) || errors.As(err, &apiErr) && permanentHTTPStatus(apiErr.Status)
+}
+
+// FetchCloudflareRecordID looks up the monitor's record ID through the
+// provider of its account.
+func FetchCloudflareRecordID(ctx context.Context, m *Monitor) (string, error) {
+	accConfig := GetMonitorAccountConfig(m)
 	if accConfig == nil {
 		return "", fmt.Errorf("account config not found for %s", m.AccountName)
 	}
+	provider, err := GetDNSProvider(accConfig)
+	if err != nil {
+		return "", err
+	}
+	return provider.GetRecordID(ctx, m.dnsRecord(m.CFZoneID, "", m.CFDomain))
+}
 
-	dnsType := m.DNSType
+func lookupCloudflareRecordID(ctx context.Context, accConfig *AccountConfig, zoneID, domain, dnsType string) (string, error) {
 	if dnsType == "" {
 		dnsType = "A"
 	}
 
 	// Create request to list records
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s&type=%s", m.CFZoneID, m.CFDomain, dnsType)
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s&type=%s", zoneID, domain, dnsType)
 
-	req, err := newCloudflareRequest("GET", url, nil, accConfig)
+	req, err := newCloudflareRequest(ctx, "GET", url, nil, accConfig)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %v", err)
 	}
 
-	resp, err := cfClient.Do(req)
+	status, body, err := doCloudflareRequest(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch CF Record ID: %v", err)
 	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
 
 	var result struct {
 		Success bool `json:"success"`
@@ -161,11 +666,260 @@ func FetchCloudflareRecordID(m *Monitor) (string, error) {
 		if len(result.Errors) > 0 {
 			errMsg = result.Errors[0].Message
 		}
-		return "", fmt.Errorf("cloudflare api error: %s", errMsg)
+		return "", &cloudflareAPIError{Status: status, Message: errMsg}
 	}
 
 	if len(result.Result) > 0 {
 		return result.Result[0].ID, nil
 	}
-	return "", fmt.Errorf("record not found")
+	return "", errRecordNotFound
+}
+
+// --- Zone / Record Discovery ---
+
+type CloudflareZone struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// DNSRecordInfo is a record as the provider serves it.
+type DNSRecordInfo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	Proxied bool   `json:"proxied"`
+	TTL     int    `json:"ttl"`
+}
+
+type cfCacheEntry struct {
+	data      interface{}
+	expiresAt time.Time
+}
+
+const cfCacheTTL = 60 * time.Second
+
+var (
+	// Short-lived cache for discovery lookups so UI dropdowns don't hammer the API
+	cfCacheMutex sync.Mutex
+	cfCache      = make(map[string]cfCacheEntry)
+)
+
+func cfCacheGet(key string) (interface{}, bool) {
+	cfCacheMutex.Lock()
+	defer cfCacheMutex.Unlock()
+
+	entry, ok := cfCache[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(cfCache, key)
+		return nil, false
+	}
+	return entry.data, true
+}
+
+func cfCacheSet(key string, data interface{}) {
+	cfCacheMutex.Lock()
+	defer cfCacheMutex.Unlock()
+
+	cfCache[key] = cfCacheEntry{data: data, expiresAt: time.Now().Add(cfCacheTTL)}
+}
+
+// cloudflareGet performs a GET against the Cloudflare API and decodes the result into out.
+// For paginated list endpoints it also returns the total number of pages.
+func cloudflareGet(ctx context.Context, url string, acc *AccountConfig, out interface{}) (int, error) {
+	req, err := newCloudflareRequest(ctx, "GET", url, nil, acc)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	_, body, err := doCloudflareRequest(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %v", err)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result     json.RawMessage `json:"result"`
+		ResultInfo struct {
+			TotalPages int `json:"total_pages"`
+		} `json:"result_info"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, fmt.Errorf("failed to parse response: %v, body: %s", err, string(body))
+	}
+
+	if !result.Success {
+		errMsg := "unknown error"
+		if len(result.Errors) > 0 {
+			errMsg = result.Errors[0].Message
+		}
+		return 0, fmt.Errorf("cloudflare api error: %s", errMsg)
+	}
+
+	if err := json.Unmarshal(result.Result, out); err != nil {
+		return 0, fmt.Errorf("failed to parse result: %v", err)
+	}
+	return result.ResultInfo.TotalPages, nil
+}
+
+func ListCloudflareZones(ctx context.Context, acc *AccountConfig) ([]CloudflareZone, error) {
+	cacheKey := "zones:" + acc.Name
+	if cached, ok := cfCacheGet(cacheKey); ok {
+		return cached.([]CloudflareZone), nil
+	}
+
+	zones := []CloudflareZone{}
+	for page := 1; ; page++ {
+		var batch []CloudflareZone
+		url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones?per_page=50&page=%d", page)
+		totalPages, err := cloudflareGet(ctx, url, acc, &batch)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, batch...)
+		if page >= totalPages {
+			break
+		}
+	}
+
+	cfCacheSet(cacheKey, zones)
+	return zones, nil
+}
+
+func ListCloudflareRecords(ctx context.Context, acc *AccountConfig, zoneID string) ([]DNSRecordInfo, error) {
+	if !validCloudflareID(zoneID) {
+		return nil, fmt.Errorf("invalid zone ID %q", zoneID)
+	}
+	cacheKey := "records:" + acc.Name + ":" + zoneID
+	if cached, ok := cfCacheGet(cacheKey); ok {
+		return cached.([]DNSRecordInfo), nil
+	}
+
+	records := []DNSRecordInfo{}
+	for page := 1; ; page++ {
+		var batch []DNSRecordInfo
+		url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?per_page=100&page=%d", zoneID, page)
+		totalPages, err := cloudflareGet(ctx, url, acc, &batch)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, batch...)
+		if page >= totalPages {
+			break
+		}
+	}
+
+	cfCacheSet(cacheKey, records)
+	return records, nil
+}
+
+// Zone, account and pool IDs are 32 lowercase hex characters
+var cloudflareIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// validCloudflareID reports whether id has the form of a Cloudflare ID, so
+// it can go into an API path as is.
+func validCloudflareID(id string) bool {
+	return cloudflareIDRe.MatchString(id)
+}
+
+// --- Credential Verification ---
+
+type CloudflareVerifyResult struct {
+	Account  string   `json:"account"`
+	AuthMode string   `json:"auth_mode"` // token, key
+	Valid    bool     `json:"valid"`
+	Status   string   `json:"status"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+func VerifyCloudflareAccount(ctx context.Context, acc *AccountConfig) CloudflareVerifyResult {
+	res := CloudflareVerifyResult{Account: acc.Name, AuthMode: acc.AuthMode()}
+
+	if res.AuthMode == "key" {
+		// Global API Key: any authenticated call to /user proves the credentials
+		var user struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		}
+		if _, err := cloudflareGet(ctx, "https://api.cloudflare.com/client/v4/user", acc, &user); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Valid = true
+		res.Status = "active"
+		return res
+	}
+
+	var token struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if _, err := cloudflareGet(ctx, "https://api.cloudflare.com/client/v4/user/tokens/verify", acc, &token); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Status = token.Status
+	res.Valid = token.Status == "active"
+
+	// Reading the token's own policies needs the "API Tokens Read" permission,
+	// so scopes are best effort.
+	var details struct {
+		Policies []struct {
+			Effect           string `json:"effect"`
+			PermissionGroups []struct {
+				Name string `json:"name"`
+			} `json:"permission_groups"`
+		} `json:"policies"`
+	}
+	if _, err := cloudflareGet(ctx, "https://api.cloudflare.com/client/v4/user/tokens/"+token.ID, acc, &details); err == nil {
+		for _, p := range details.Policies {
+			for _, g := range p.PermissionGroups {
+				res.Scopes = append(res.Scopes, g.Name)
+			}
+		}
+	}
+	return res
+}
+
+func VerifyCloudflareAccounts() {
+	accounts := GetConfig().Accounts
+	for i := range accounts {
+		res := VerifyCloudflareAccount(appCtx, &accounts[i])
+		if res.Valid {
+			slog.Info("Cloudflare credentials verified", "account", res.Account, "auth_mode", res.AuthMode, "status", res.Status)
+		} else {
+			slog.Error("Cloudflare credentials invalid", "account", res.Account, "auth_mode", res.AuthMode, "status", res.Status, "error", res.Error)
+		}
+	}
+}
+
+func FetchCloudflareRecordContent(ctx context.Context, m *Monitor) (string, error) {
+	record, err := FetchCloudflareRecord(ctx, m)
+	if err != nil {
+		return "", err
+	}
+	return record.Content, nil
+}
+
+// FetchCloudflareRecord reads the monitor's primary record live from its provider.
+func FetchCloudflareRecord(ctx context.Context, m *Monitor) (DNSRecordInfo, error) {
+	if m.CFZoneID == "" || m.CFRecordID == "" {
+		return DNSRecordInfo{}, fmt.Errorf("missing zone or record id")
+	}
+
+	acc := GetMonitorAccountConfig(m)
+	if acc == nil {
+		return DNSRecordInfo{}, fmt.Errorf("account config not found for %s", m.AccountName)
+	}
+	provider, err := GetDNSProvider(acc)
+	if err != nil {
+		return DNSRecordInfo{}, err
+	}
+	return provider.GetRecord(ctx, m.dnsRecord(m.CFZoneID, m.CFRecordID, m.CFDomain))
 }
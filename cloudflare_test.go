@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeRecords serves record lookups and updates on the fake Cloudflare. ids
+// maps domain to its current record ID; a PATCH of any other ID fails with
+// 404 like Cloudflare does.
+type fakeRecords struct {
+	mu      sync.Mutex
+	ids     map[string]string
+	failAll bool // Every update fails as if the record had moved again
+	lookups int
+	updates []string // Record IDs PATCHed, in order
+}
+
+func (f *fakeRecords) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == http.MethodGet {
+		f.lookups++
+		if id, ok := f.ids[r.URL.Query().Get("name")]; ok {
+			fmt.Fprintf(w, `{"success": true, "result": [{"id": %q}]}`, id)
+			return
+		}
+		fmt.Fprint(w, `{"success": true, "result": []}`)
+		return
+	}
+	id := path.Base(r.URL.Path)
+	f.updates = append(f.updates, id)
+	for _, current := range f.ids {
+		if id == current && !f.failAll {
+			fmt.Fprint(w, `{"success": true, "result": {}}`)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"success": false, "errors": [{"code": 81044, "message": "Record not found"}]}`)
+}
+
+func TestUpdateCloudflareDNSStaleRecordIDs(t *testing.T) {
+	tests := []struct {
+		name        string
+		ids         map[string]string // Live record IDs
+		extraID     string            // Stored ID of the extra record
+		wantSuccess bool
+		wantUpdates []string
+		wantPrimary string // Stored IDs afterwards
+		wantExtra   string
+	}{
+		{"current ids", map[string]string{"web.example.com": "rec-old", "api.example.com": "rec-1"}, "rec-1",
+			true, []string{"rec-old", "rec-1"}, "rec-old", "rec-1"},
+		{"stale primary refreshed and retried", map[string]string{"web.example.com": "rec-new", "api.example.com": "rec-1"}, "rec-1",
+			true, []string{"rec-old", "rec-new", "rec-1"}, "rec-new", "rec-1"},
+		{"stale extra refreshed and retried", map[string]string{"web.example.com": "rec-old", "api.example.com": "rec-2"}, "rec-1",
+			true, []string{"rec-old", "rec-1", "rec-2"}, "rec-old", "rec-2"},
+		{"missing extra id looked up", map[string]string{"web.example.com": "rec-old", "api.example.com": "rec-2"}, "",
+			true, []string{"rec-old", "rec-2"}, "rec-old", "rec-2"},
+		{"primary deleted", map[string]string{"api.example.com": "rec-1"}, "rec-1",
+			false, []string{"rec-old", "rec-1"}, "", "rec-1"},
+		{"extra deleted", map[string]string{"web.example.com": "rec-old"}, "rec-1",
+			false, []string{"rec-old", "rec-1"}, "rec-old", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			m := createTestMonitor(t)
+			r := MonitorRecord{MonitorID: m.ID, Domain: "api.example.com", RecordID: tt.extraID}
+			DB.Create(&r)
+			f := &fakeRecords{ids: tt.ids}
+			fakeCloudflare(t, f.serve)
+
+			res := UpdateCloudflareDNSResult(&m, "2.2.2.2")
+			if res.Success != tt.wantSuccess {
+				t.Errorf("success = %v, want %v (%s)", res.Success, tt.wantSuccess, res.Error)
+			}
+			if !slices.Equal(f.updates, tt.wantUpdates) {
+				t.Errorf("updates with %v, want %v", f.updates, tt.wantUpdates)
+			}
+			var got Monitor
+			DB.Preload("Records").First(&got, m.ID)
+			if got.CFRecordID != tt.wantPrimary || got.Records[0].RecordID != tt.wantExtra {
+				t.Errorf("stored record ids = %q, %q; want %q, %q", got.CFRecordID, got.Records[0].RecordID, tt.wantPrimary, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestUpdateCloudflareDNSRetriesStaleIDOnce(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+	// A refreshed ID that fails too isn't refreshed again
+	f := &fakeRecords{ids: map[string]string{"web.example.com": "rec-new"}, failAll: true}
+	fakeCloudflare(t, f.serve)
+
+	if res := UpdateCloudflareDNSResult(&m, "2.2.2.2"); res.Success {
+		t.Fatal("update succeeded, want failure")
+	}
+	if f.lookups != 1 || !slices.Equal(f.updates, []string{"rec-old", "rec-new"}) {
+		t.Errorf("%d lookups, updates with %v; want 1 lookup, updates with [rec-old rec-new]", f.lookups, f.updates)
+	}
+}
+
+func TestValidCloudflareID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"023e105f4ecef8ad9ca31a8372d0c353", true},
+		{"", false},
+		{"023e105f4ecef8ad9ca31a8372d0c35", false},
+		{"023E105F4ECEF8AD9CA31A8372D0C353", false},
+		{"../../accounts/023e105f4ecef8ad9c", false},
+		{"023e105f4ecef8ad9ca31a8372d0c353?x", false},
+	}
+	for _, tt := range tests {
+		if got := validCloudflareID(tt.id); got != tt.want {
+			t.Errorf("validCloudflareID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestGetCloudflareRecordsRejectsBadZoneID(t *testing.T) {
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected Cloudflare request %s", r.URL)
+	})
+
+	w := serveAPI("GET", "/cloudflare/records", "/cloudflare/records?zone_id=..%2Fuser%2Ftokens", "", GetCloudflareRecords)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", w.Code, w.Body)
+	}
+}
+
+func TestRecordIDLookupFailurePermanence(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantPermanent bool
+	}{
+		{"record not found", http.StatusOK, `{"success": true, "result": []}`, true},
+		{"forbidden", http.StatusForbidden, `{"success": false, "errors": [{"message": "Authentication error"}]}`, true},
+		{"rate limited", http.StatusTooManyRequests, `{"success": false, "errors": [{"message": "Rate limited"}]}`, false},
+		{"server error", http.StatusInternalServerError, `{"success": false, "errors": [{"message": "Internal error"}]}`, false},
+		{"not json", http.StatusBadGateway, `<html>Bad gateway</html>`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			m := createTestMonitor(t)
+			m.CFRecordID = ""
+			fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			})
+
+			res := UpdateCloudflareDNSResult(&m, "2.2.2.2")
+			if res.Success {
+				t.Fatal("update succeeded without a record ID")
+			}
+			if res.Permanent() != tt.wantPermanent {
+				t.Errorf("Permanent() = %v, want %v (%s)", res.Permanent(), tt.wantPermanent, res.Error)
+			}
+		})
+	}
+}
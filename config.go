@@ -1,8 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,9 +21,50 @@ import (
 
 type AccountConfig struct {
 	Name     string `yaml:"name"`
+	Provider string `yaml:"provider"` // DNS provider, cloudflare (default)
 	ApiToken string `yaml:"api_token"`
 	Email    string `yaml:"email"`
 	ApiKey   string `yaml:"api_key"`
+	// Read the values above from files (Docker/k8s secrets); a file wins
+	// over the inline value
+	ApiTokenFile string `yaml:"api_token_file"`
+	EmailFile    string `yaml:"email_file"`
+	ApiKeyFile   string `yaml:"api_key_file"`
+}
+
+// Placeholder token shipped in the example config
+const exampleApiToken = "YOUR_CLOUDFLARE_API_TOKEN"
+
+// hasCredentials reports whether the account has real credentials rather
+// than being empty or the example placeholder.
+func (a *AccountConfig) hasCredentials() bool {
+	if a.ApiToken != "" {
+		return a.ApiToken != exampleApiToken
+	}
+	return a.Email != "" && a.ApiKey != ""
+}
+
+// ProviderName is the account's DNS provider, cloudflare when unset.
+func (a *AccountConfig) ProviderName() string {
+	if a.Provider == "" {
+		return defaultDNSProvider
+	}
+	return strings.ToLower(a.Provider)
+}
+
+// AuthMode is "token" for an API token, "key" for email + Global API Key.
+func (a *AccountConfig) AuthMode() string {
+	if a.ApiToken != "" {
+		return "token"
+	}
+	return "key"
+}
+
+type APIKeyConfig struct {
+	Name    string `yaml:"name"`
+	Key     string `yaml:"key"`
+	Role    string `yaml:"role"` // admin (default), readonly
+	Revoked bool   `yaml:"revoked"`
 }
 
 type Config struct {
@@ -22,21 +73,90 @@ type Config struct {
 		Debug       bool   `yaml:"debug"`
 		AuthEnabled bool   `yaml:"auth_enabled"`
 		JwtSecret   string `yaml:"jwt_secret"`
+		LogLevel    string `yaml:"log_level"`  // debug, info, warn, error
+		LogFormat   string `yaml:"log_format"` // text, json
+		AccessLog   bool   `yaml:"access_log"` // Per-request Gin logs
+		// Read jwt_secret from this file instead (Docker/k8s secrets)
+		JwtSecretFile string `yaml:"jwt_secret_file"`
+		// Paths excluded from the access log (health probes, scrapers)
+		AccessLogSkipPaths []string `yaml:"access_log_skip_paths"`
+		// Sync CurrentIP with live Cloudflare records at boot (one API call per monitor)
+		ReconcileOnStartup bool `yaml:"reconcile_on_startup"`
+		// Check every monitor once right after boot instead of waiting a full interval (default true)
+		CheckOnStartup bool `yaml:"check_on_startup"`
+		// Static keys for machine clients, sent via X-API-Key
+		ApiKeys []APIKeyConfig `yaml:"api_keys"`
+		// Initial admin user created on first run (password defaults to jwt_secret)
+		AdminUsername string `yaml:"admin_username"`
+		AdminPassword string `yaml:"admin_password"`
+		// Refuse to start with a default JWT secret or with auth disabled
+		StrictSecurity bool `yaml:"strict_security"`
+		// Login session lifetime (JWT exp and cookie MaxAge), e.g. "24h", "30m"
+		SessionTTL time.Duration `yaml:"session_ttl"`
+		// Re-issue the session cookie when a request arrives in the last quarter of its life
+		SessionSliding bool `yaml:"session_sliding"`
+		// Deadline for each API request, e.g. "30s" (0 = none). Cloudflare
+		// lookups made for the request are cancelled when it passes.
+		RequestTimeout time.Duration `yaml:"request_timeout"`
+		// Grace period on SIGINT/SIGTERM for running jobs (e.g. a failover
+		// mid-update) and API requests to finish, e.g. "30s"
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// Public URL of the dashboard, e.g. https://cfguard.example.com
+		BaseURL string `yaml:"base_url"`
+		// Serve GET /api/public/status without login (monitors with public: true)
+		PublicStatusEnabled bool `yaml:"public_status_enabled"`
+		// Reverse proxies (CIDR or single IP) whose X-Forwarded-For is believed
+		// when working out the client IP for allowed_cidrs, the event log and
+		// login logs. Empty = trust none: the client IP is the peer address.
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// Networks (CIDR or single IP) allowed to call /api; empty = anyone
+		AllowedCIDRs []string `yaml:"allowed_cidrs"`
+		// Routes under /api that stay reachable from anywhere (webhooks, public status)
+		AllowedCIDRsSkipPaths []string `yaml:"allowed_cidrs_skip_paths"`
+		allowedNets           []*net.IPNet
+		// Networks allowed to reach /healthz and /metrics, which sit outside
+		// /api and aren't covered by allowed_cidrs; empty = anyone
+		MetricsAllowedCIDRs []string `yaml:"metrics_allowed_cidrs"`
+		metricsNets         []*net.IPNet
+		Cookie              struct {
+			// nil = auto (secure when base_url is https)
+			Secure   *bool  `yaml:"secure"`
+			SameSite string `yaml:"same_site"` // lax (default), strict, none
+			Domain   string `yaml:"domain"`
+		} `yaml:"cookie"`
 	} `yaml:"server"`
 	Database struct {
 		Path string `yaml:"path"`
+		// Pruned daily; 0 = keep forever
+		HistoryRetentionDays int `yaml:"history_retention_days"`
+		EventRetentionDays   int `yaml:"event_retention_days"`
+		// Days deleted monitors can be restored before they are purged
+		TrashRetentionDays int `yaml:"trash_retention_days"`
 	} `yaml:"database"`
+	// Notification language: zh (default), en
+	Language     string          `yaml:"language"`
 	Accounts     []AccountConfig `yaml:"accounts"`
 	Notification struct {
 		DingTalk struct {
 			Enabled     bool   `yaml:"enabled"`
 			AccessToken string `yaml:"access_token"`
 			Secret      string `yaml:"secret"`
+			MsgType     string `yaml:"msg_type"` // text (default), markdown
+			At          struct {
+				Mobiles []string `yaml:"mobiles"`
+				All     bool     `yaml:"all"`
+			} `yaml:"at"`
 		} `yaml:"dingtalk"`
 		Telegram struct {
 			Enabled  bool   `yaml:"enabled"`
 			BotToken string `yaml:"bot_token"`
 			ChatID   string `yaml:"chat_id"`
+			// Empty (plain text), MarkdownV2 or HTML
+			ParseMode string `yaml:"parse_mode"`
+			// Forum topic to post into (0 = main chat)
+			MessageThreadID int `yaml:"message_thread_id"`
+			// Deliver non-failover messages silently
+			DisableNotification bool `yaml:"disable_notification"`
 		} `yaml:"telegram"`
 		Email struct {
 			Enabled  bool   `yaml:"enabled"`
@@ -46,30 +166,598 @@ type Config struct {
 			Password string `yaml:"password"`
 			To       string `yaml:"to"`
 		} `yaml:"email"`
+		Gotify struct {
+			Enabled   bool   `yaml:"enabled"`
+			ServerURL string `yaml:"server_url"`
+			AppToken  string `yaml:"app_token"`
+		} `yaml:"gotify"`
+		Ntfy struct {
+			Enabled   bool   `yaml:"enabled"`
+			ServerURL string `yaml:"server_url"` // Defaults to https://ntfy.sh
+			Topic     string `yaml:"topic"`
+			Token     string `yaml:"token"` // Optional access token for protected topics
+		} `yaml:"ntfy"`
+		Pushover struct {
+			Enabled bool   `yaml:"enabled"`
+			Token   string `yaml:"token"`    // Application API token
+			UserKey string `yaml:"user_key"` // User or group key
+		} `yaml:"pushover"`
+		Teams struct {
+			Enabled    bool   `yaml:"enabled"`
+			WebhookURL string `yaml:"webhook_url"`
+			// messagecard (default) for Office 365 connector webhooks,
+			// adaptive for Power Automate / Workflows webhooks
+			Format string `yaml:"format"`
+		} `yaml:"teams"`
+		Matrix struct {
+			Enabled       bool   `yaml:"enabled"`
+			HomeserverURL string `yaml:"homeserver_url"` // e.g. https://matrix.example.com
+			AccessToken   string `yaml:"access_token"`   // Of the bot user, which must have joined the room
+			RoomID        string `yaml:"room_id"`        // Internal ID, e.g. !abc123:example.com
+		} `yaml:"matrix"`
+		// Prepended to every message and used as the email subject and push
+		// title, to tell instances apart (default CFGuard)
+		TitlePrefix string `yaml:"title_prefix"`
+		// Per-event overrides of the built-in messages, keyed by event kind
+		// (failover, recovery, manual_failover, manual_restore, scheduled_switch, circuit_open, digest, batch, drift, drift_corrected, no_healthy,
+		// monitor_created, monitor_updated, monitor_deleted)
+		Templates map[string]NotifyTemplate `yaml:"templates"`
+		// Notify when monitors are created, updated or deleted through the API (default true)
+		ConfigChanges bool `yaml:"config_changes"`
+		// Severities each channel is sent, keyed by channel (dingtalk,
+		// telegram, email, ...); channels not listed get all of them
+		Severities map[string][]string `yaml:"severities"`
+		// Hold non-bypassed notifications during a daily window
+		QuietHours QuietHours `yaml:"quiet_hours"`
+		// Group bursts of failover/recovery notifications (e.g. a provider
+		// outage) into one message
+		Batch struct {
+			Enabled  bool `yaml:"enabled"`
+			Window   int  `yaml:"window"`    // Seconds, default 60
+			MinCount int  `yaml:"min_count"` // Events within the window before batching starts, default 3
+		} `yaml:"batch"`
 	} `yaml:"notification"`
 
+	Cloudflare struct {
+		// API request timeout in seconds (default 15), separate from check timeouts
+		Timeout int `yaml:"timeout"`
+	} `yaml:"cloudflare"`
+
+	// Health check settings shared by all monitors
+	Checks struct {
+		// Skip TLS certificate verification for every https/grpc check (the
+		// previous default); prefer insecure_tls on the monitors that need it
+		InsecureTLS bool `yaml:"insecure_tls"`
+		// Thresholds for monitors that don't set their own, keyed by check
+		// type (ping, http, https, tcp, grpc)
+		Defaults map[string]CheckDefaults `yaml:"defaults"`
+		// User-Agent of http/https checks, default CFGuard-Monitor/1.0.
+		// Monitors can set their own with user_agent.
+		UserAgent string `yaml:"user_agent"`
+		// Send a unique X-Request-ID with every http/https check, to find
+		// the check requests in backend logs
+		RequestID bool `yaml:"request_id"`
+		// Seconds a check result is reused by other monitors running the
+		// identical check (same type, target, IP, port and options).
+		// 0 = off, every monitor checks independently.
+		ShareTTL int `yaml:"share_ttl"`
+	} `yaml:"checks"`
+
+	// Stop retrying automatic DNS switches after repeated failures
+	CircuitBreaker struct {
+		Threshold int `yaml:"threshold"` // Consecutive DNS update failures, 0 = off
+		Cooldown  int `yaml:"cooldown"`  // Seconds before retrying
+	} `yaml:"circuit_breaker"`
+
+	// Periodically compare live Cloudflare records with the expected IP
+	DriftCheck struct {
+		Interval    int  `yaml:"interval"`     // Minutes, 0 = off
+		AutoCorrect bool `yaml:"auto_correct"` // Write the expected IP back instead of only alerting
+	} `yaml:"drift_check"`
+
 	// Initial Monitors for seeding
 	Monitors []MonitorConfig `yaml:"monitors"`
 }
 
-var AppConfig Config
+type CheckDefaults struct {
+	Retries         int `yaml:"retries"`
+	RecoveryRetries int `yaml:"recovery_retries"`
+}
+
+// The running configuration. Readers may hold on to what GetConfig returned
+// while a reload swaps in a new one, so a published Config is never modified.
+var (
+	appConfig   atomic.Pointer[Config]
+	appConfigMu sync.Mutex // Serializes UpdateConfig
+)
+
+func init() {
+	c := defaultConfig()
+	appConfig.Store(&c)
+}
+
+// GetConfig returns the current configuration. It must be treated as
+// read-only; functions reading several fields should call it once.
+func GetConfig() *Config {
+	return appConfig.Load()
+}
+
+// SetConfig atomically replaces the configuration.
+func SetConfig(c *Config) {
+	appConfigMu.Lock()
+	defer appConfigMu.Unlock()
+	appConfig.Store(c)
+}
+
+// UpdateConfig swaps in a copy of the configuration changed by fn. The copy
+// is shallow: fn must clone a slice or map before changing its elements.
+func UpdateConfig(fn func(c *Config)) {
+	appConfigMu.Lock()
+	defer appConfigMu.Unlock()
+	c := *appConfig.Load()
+	fn(&c)
+	appConfig.Store(&c)
+}
+
+// Path LoadConfig read from, for re-reading monitors at runtime
+var configFile string
+
+// Placeholder secrets shipped in the example config and docs
+var defaultJwtSecrets = []string{
+	"change-this-secret-key-in-production",
+	"please-change-this-secret-key-in-production",
+	"CHANGE_ME_IN_PRODUCTION",
+}
+
+// IsDefaultJwtSecret reports whether the JWT secret is still a known placeholder.
+func IsDefaultJwtSecret() bool {
+	return isDefaultJwtSecret(GetConfig().Server.JwtSecret)
+}
+
+func isDefaultJwtSecret(secret string) bool {
+	for _, s := range defaultJwtSecrets {
+		if secret == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSecurityConfig warns about deployments anyone could log into or forge
+// tokens for. With strict_security enabled these problems are fatal.
+func CheckSecurityConfig() {
+	server := GetConfig().Server
+	var problems []string
+	if !server.AuthEnabled {
+		problems = append(problems, "auth_enabled is false: the API and UI are open to anyone who can reach them")
+	} else if server.JwtSecret == "" {
+		problems = append(problems, "jwt_secret is empty: session tokens can be forged")
+	} else if isDefaultJwtSecret(server.JwtSecret) {
+		problems = append(problems, "jwt_secret is still the default placeholder: session tokens can be forged")
+	}
+
+	for _, p := range problems {
+		slog.Warn("!!! INSECURE CONFIGURATION: " + p)
+	}
+	if len(problems) > 0 && server.StrictSecurity {
+		log.Fatal("Refusing to start: strict_security is enabled and the configuration is insecure")
+	}
+}
 
-func LoadConfig() {
-	// Set Defaults
-	AppConfig.Server.AuthEnabled = true
+// LoadConfig reads the config file at path, or config.yaml in the working
+// directory when path is empty. Only the implicit config.yaml may be missing.
+func LoadConfig(path string) {
+	explicit := path != ""
+	if !explicit {
+		path = "config.yaml"
+	}
+	configFile = path
 
-	f, err := os.Open("config.yaml")
+	data, err := os.ReadFile(path)
 	if err != nil {
+		if explicit {
+			log.Fatalf("Failed to open config file %s: %v", path, err)
+		}
 		log.Println("config.yaml not found, using defaults")
-		AppConfig.Server.Port = 8099
-		AppConfig.Database.Path = "instance/cfguard.db"
+		cfg := defaultConfig()
+		cfg.Server.Port = 8099
+		cfg.Database.Path = "instance/cfguard.db"
+		SetConfig(&cfg)
 		return
 	}
-	defer f.Close()
 
-	decoder := yaml.NewDecoder(f)
-	err = decoder.Decode(&AppConfig)
+	cfg, err := ParseConfig(data)
+	if err != nil {
+		log.Fatalf("Invalid config %s: %v", path, err)
+	}
+	SetConfig(&cfg)
+}
+
+// initNetworks parses allowed_cidrs and metrics_allowed_cidrs, and checks
+// trusted_proxies.
+func (c *Config) initNetworks() error {
+	nets, err := parseCIDRs(c.Server.AllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("allowed_cidrs: %w", err)
+	}
+	metricsNets, err := parseCIDRs(c.Server.MetricsAllowedCIDRs)
+	if err != nil {
+		return fmt.Errorf("metrics_allowed_cidrs: %w", err)
+	}
+	if _, err := parseCIDRs(c.Server.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	c.Server.allowedNets = nets
+	c.Server.metricsNets = metricsNets
+	return nil
+}
+
+// readSecretFiles replaces secrets that have a *_file setting with the
+// contents of that file, minus trailing newlines.
+func (c *Config) readSecretFiles() error {
+	read := func(key, path string, dst *string) error {
+		if path == "" {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		*dst = strings.TrimRight(string(data), "\r\n")
+		return nil
+	}
+
+	if err := read("server.jwt_secret_file", c.Server.JwtSecretFile, &c.Server.JwtSecret); err != nil {
+		return err
+	}
+	for i := range c.Accounts {
+		a := &c.Accounts[i]
+		prefix := fmt.Sprintf("accounts[%d].", i)
+		if err := read(prefix+"api_token_file", a.ApiTokenFile, &a.ApiToken); err != nil {
+			return err
+		}
+		if err := read(prefix+"email_file", a.EmailFile, &a.Email); err != nil {
+			return err
+		}
+		if err := read(prefix+"api_key_file", a.ApiKeyFile, &a.ApiKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseCIDRs accepts CIDR ranges and bare IPs, which match only themselves.
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP or CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// IPAllowed reports whether ip may call the API under server.allowed_cidrs.
+func (c *Config) IPAllowed(ip string) bool {
+	return len(c.Server.AllowedCIDRs) == 0 || ipInNets(ip, c.Server.allowedNets)
+}
+
+// MetricsIPAllowed reports whether ip may reach /healthz and /metrics under
+// server.metrics_allowed_cidrs.
+func (c *Config) MetricsIPAllowed(ip string) bool {
+	return len(c.Server.MetricsAllowedCIDRs) == 0 || ipInNets(ip, c.Server.metricsNets)
+}
+
+func ipInNets(ip string, nets []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultConfig holds the settings used when config.yaml leaves them out.
+func defaultConfig() Config {
+	var c Config
+	c.Server.AuthEnabled = true
+	c.Server.AccessLog = true
+	c.Language = "zh"
+	c.Server.AccessLogSkipPaths = []string{"/healthz", "/metrics"}
+	c.Server.AllowedCIDRsSkipPaths = []string{"/api/public/status", "/api/monitors/:id/trigger"}
+	c.Server.SessionTTL = 24 * time.Hour
+	c.Server.RequestTimeout = 30 * time.Second
+	c.Server.ShutdownTimeout = 30 * time.Second
+	c.Server.CheckOnStartup = true
+	c.Database.HistoryRetentionDays = 30
+	c.Database.TrashRetentionDays = 7
+	c.CircuitBreaker.Threshold = 5
+	c.CircuitBreaker.Cooldown = 600
+	c.Notification.Ntfy.ServerURL = "https://ntfy.sh"
+	c.Notification.Batch.Window = 60
+	c.Notification.Batch.MinCount = 3
+	c.Notification.ConfigChanges = true
+	return c
+}
+
+// ParseConfig parses config file contents over the defaults and validates
+// them, including the monitors' record and check settings. LoadConfig and the
+// raw config endpoint both go through it.
+func ParseConfig(data []byte) (Config, error) {
+	c := defaultConfig()
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return c, err
+	}
+	if err := c.Notification.QuietHours.init(); err != nil {
+		return c, fmt.Errorf("notification.quiet_hours: %w", err)
+	}
+	if err := validateChannelSeverities(c.Notification.Severities); err != nil {
+		return c, fmt.Errorf("notification.severities: %w", err)
+	}
+	if err := c.initNetworks(); err != nil {
+		return c, fmt.Errorf("server.%w", err)
+	}
+	if err := c.readSecretFiles(); err != nil {
+		return c, err
+	}
+	if err := validateAccountProviders(c.Accounts); err != nil {
+		return c, fmt.Errorf("accounts: %w", err)
+	}
+	for _, mc := range c.Monitors {
+		m := mc.ToMonitor()
+		if err := m.ValidateRecordFields(); err != nil {
+			return c, fmt.Errorf("monitor %q: %w", mc.Name, err)
+		}
+		if err := m.ValidateCheckFields(); err != nil {
+			return c, fmt.Errorf("monitor %q: %w", mc.Name, err)
+		}
+		if err := ValidateBackups(m.Backups, m.BackupIP, m.OriginalIP); err != nil {
+			return c, fmt.Errorf("monitor %q: %w", mc.Name, err)
+		}
+		for _, s := range mc.Schedules {
+			if _, err := parseRevertAfter(s.RevertAfter); err != nil {
+				return c, fmt.Errorf("monitor %q: %w", mc.Name, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// ConfigFile is the path of the config file in use.
+func ConfigFile() string {
+	return configFile
+}
+
+// WriteConfigFile replaces the config file atomically: the new contents go to
+// a temporary file next to it, which is then renamed over it.
+func WriteConfigFile(data []byte) error {
+	mode := os.FileMode(0o600)
+	if fi, err := os.Stat(configFile); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(configFile), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), configFile)
+}
+
+// Shown instead of secrets by GET /api/config/raw. Sending it back in an
+// edited file keeps the value currently stored at that place.
+const maskedSecret = "********"
+
+// Keys whose values are masked, wherever they appear in the file
+var secretConfigKeys = map[string]bool{
+	"api_token": true, "api_key": true, "jwt_secret": true, "admin_password": true,
+	"key": true, "secret": true, "password": true, "token": true, "access_token": true,
+	"app_token": true, "bot_token": true, "user_key": true, "webhook_secret": true,
+	"webhook_url": true, "cf_api_token": true,
+}
+
+// secretNode is a masked value: its location in the file and its YAML path,
+// e.g. accounts[0].api_token.
+type secretNode struct {
+	path string
+	node *yaml.Node
+}
+
+func findSecrets(n *yaml.Node, path string, out *[]secretNode) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			findSecrets(c, path, out)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			findSecrets(c, fmt.Sprintf("%s[%d]", path, i), out)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			p := key.Value
+			if path != "" {
+				p = path + "." + key.Value
+			}
+			if value.Kind == yaml.ScalarNode && secretConfigKeys[key.Value] && value.Value != "" {
+				*out = append(*out, secretNode{path: p, node: value})
+				continue
+			}
+			findSecrets(value, p, out)
+		}
+	}
+}
+
+func parseSecrets(data []byte) ([]secretNode, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var secrets []secretNode
+	findSecrets(&doc, "", &secrets)
+	return secrets, nil
+}
+
+// replaceScalars rewrites the given single-line scalars in place, keeping the
+// rest of the file (comments, layout) as it was.
+func replaceScalars(data []byte, values map[*yaml.Node]string) ([]byte, error) {
+	lines := strings.Split(string(data), "\n")
+	// Right to left, so earlier columns on the same line stay valid
+	nodes := make([]*yaml.Node, 0, len(values))
+	for n := range values {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Line != nodes[j].Line {
+			return nodes[i].Line < nodes[j].Line
+		}
+		return nodes[i].Column > nodes[j].Column
+	})
+	for _, n := range nodes {
+		if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || n.Line < 1 || n.Line > len(lines) {
+			return nil, fmt.Errorf("line %d: multi-line secrets are not supported", n.Line)
+		}
+		line := lines[n.Line-1]
+		start := n.Column - 1
+		end, err := scalarEnd(line, start, n.Style)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		quoted, _ := json.Marshal(values[n]) // A JSON string is a valid YAML double-quoted scalar
+		lines[n.Line-1] = line[:start] + string(quoted) + line[end:]
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// scalarEnd finds where the scalar starting at line[start:] ends.
+func scalarEnd(line string, start int, style yaml.Style) (int, error) {
+	if start < 0 || start >= len(line) {
+		return 0, fmt.Errorf("value not found")
+	}
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			switch line[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(line); i++ {
+			if line[i] != '\'' {
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	default:
+		// Plain scalars run to a comment or the end of the line
+		end := len(line)
+		if i := strings.Index(line[start:], " #"); i >= 0 {
+			end = start + i
+		}
+		return start + len(strings.TrimRight(line[start:end], " \t\r")), nil
+	}
+	return 0, fmt.Errorf("unterminated quoted value")
+}
+
+// MaskConfigSecrets replaces every secret value in the file with maskedSecret.
+func MaskConfigSecrets(data []byte) ([]byte, error) {
+	secrets, err := parseSecrets(data)
+	if err != nil {
+		return nil, err
+	}
+	values := map[*yaml.Node]string{}
+	for _, s := range secrets {
+		values[s.node] = maskedSecret
+	}
+	return replaceScalars(data, values)
+}
+
+// UnmaskConfigSecrets puts the values from current back where an edited file
+// still has maskedSecret. A masked value with no counterpart at the same path
+// (e.g. a moved list entry) is an error rather than a silently lost secret.
+func UnmaskConfigSecrets(data, current []byte) ([]byte, error) {
+	secrets, err := parseSecrets(data)
 	if err != nil {
-		log.Fatal("Failed to parse config.yaml:", err)
+		return nil, err
+	}
+	values := map[*yaml.Node]string{}
+	var stored map[string]string
+	for _, s := range secrets {
+		if s.node.Value != maskedSecret {
+			continue
+		}
+		if stored == nil {
+			stored = map[string]string{}
+			if orig, err := parseSecrets(current); err == nil {
+				for _, o := range orig {
+					stored[o.path] = o.node.Value
+				}
+			}
+		}
+		v, ok := stored[s.path]
+		if !ok {
+			return nil, fmt.Errorf("%s is masked but has no stored value, enter it again", s.path)
+		}
+		values[s.node] = v
+	}
+	if len(values) == 0 {
+		return data, nil
+	}
+	return replaceScalars(data, values)
+}
+
+// ReadMonitorConfigs re-reads the monitors section of the config file. Other
+// settings are only applied at startup.
+func ReadMonitorConfigs() ([]MonitorConfig, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var c struct {
+		Monitors []MonitorConfig `yaml:"monitors"`
+	}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", configFile, err)
 	}
+	return c.Monitors, nil
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+// Run with -race: readers must never see a config while it is being swapped.
+func TestConfigReloadUnderConcurrentReads(t *testing.T) {
+	cfg := defaultConfig()
+	SetConfig(&cfg)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				c := GetConfig()
+				_ = c.Server.Port
+				_ = len(c.Accounts)
+				for _, acc := range c.Accounts {
+					_ = acc.Name
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		next := defaultConfig()
+		next.Server.Port = i
+		next.Accounts = []AccountConfig{{Name: "a"}}
+		SetConfig(&next)
+		UpdateConfig(func(c *Config) {
+			c.Accounts = append(append([]AccountConfig(nil), c.Accounts...), AccountConfig{Name: "b"})
+			c.Server.Port++
+		})
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := GetConfig(); got.Server.Port != 1000 || len(got.Accounts) != 2 {
+		t.Fatalf("final config: port %d, %d accounts; want 1000, 2", got.Server.Port, len(got.Accounts))
+	}
+}
+
+func TestUpdateConfigKeepsConcurrentChanges(t *testing.T) {
+	cfg := defaultConfig()
+	SetConfig(&cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			UpdateConfig(func(c *Config) { c.Server.Port++ })
+		}()
+	}
+	wg.Wait()
+
+	if got := GetConfig().Server.Port; got != 100 {
+		t.Fatalf("port = %d after 100 increments, want 100", got)
+	}
+}
+
+// The example config goes through the same validation as a real one at startup.
+func TestExampleConfigParses(t *testing.T) {
+	data, err := os.ReadFile("config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseConfig(data); err != nil {
+		t.Errorf("config.example.yaml: %v", err)
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/glebarez/sqlite"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -17,7 +22,7 @@ var DB *gorm.DB
 
 func InitDB() {
 	var err error
-	dbPath := AppConfig.Database.Path
+	dbPath := GetConfig().Database.Path
 	if dbPath == "" {
 		dbPath = "instance/cfguard.db"
 	}
@@ -49,26 +54,48 @@ func InitDB() {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Auto Migrate
-	err = DB.AutoMigrate(&Monitor{}, &Schedule{})
+	// Auto Migrate the base tables (new columns only); everything else is a
+	// numbered migration in migrations.go
+	err = DB.AutoMigrate(&Monitor{}, &Schedule{}, &User{}, &Event{}, &GlobalConfig{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	if err := RunMigrations(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 }
 
 func SeedMonitors() {
-	if len(AppConfig.Monitors) == 0 {
+	if len(GetConfig().Monitors) == 0 {
 		return
 	}
 
 	log.Println("Syncing monitors from config.yaml...")
-	for _, mc := range AppConfig.Monitors {
+	SyncMonitors(GetConfig().Monitors, "")
+	slog.Info("Monitor sync complete")
+}
+
+// Serializes syncs so concurrent reseeds don't create a monitor twice
+var syncMu sync.Mutex
+
+// SyncMonitors creates or updates the given monitors, matched by name. The
+// config is the source of truth for configuration fields; state (status,
+// current IP, counters) stays as the database has it. It returns the names
+// of the created and updated monitors.
+// A sync made through the API passes its actor: created monitors and those
+// whose config actually changed are then notified as config changes. The
+// startup sync passes none.
+func SyncMonitors(monitors []MonitorConfig, actor string) (created, updated []string) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+
+	for _, mc := range monitors {
 		// Convert Config to Monitor Model (with defaults applied)
 		configMonitor := mc.ToMonitor()
 
 		// Check if monitor exists by name
 		var existing Monitor
-		result := DB.Where("name = ?", mc.Name).First(&existing)
+		result := DB.Preload("Schedules").Preload("Records").Preload("Backups").Preload("Checks").Where("name = ?", mc.Name).First(&existing)
 
 		if result.Error == nil {
 			// Found: Update Configurable Fields ONLY
@@ -80,34 +107,115 @@ func SeedMonitors() {
 			// configMonitor contains all config fields + defaults.
 			// It has ID=0, Status="", etc.
 
+			// A record ID looked up earlier stays valid while the config doesn't
+			// set one and the record is the same
+			recordID := configMonitor.CFRecordID
+			if recordID == "" && configMonitor.CFZoneID == existing.CFZoneID && configMonitor.CFDomain == existing.CFDomain {
+				recordID = existing.CFRecordID
+			}
+			oldZoneID := existing.CFZoneID
+			before := existing // Updates writes the new values into existing
+
 			// Use explicit update to ensure we don't overwrite ID or State
-			DB.Model(&existing).Updates(map[string]interface{}{
+			unlock := lockMonitor(existing.ID)
+			err := DB.Model(&existing).Updates(map[string]interface{}{
 				"account_name":     configMonitor.AccountName,
 				"target":           configMonitor.Target,
 				"type":             configMonitor.Type,
+				"port":             configMonitor.Port,
+				"source_ip":        configMonitor.SourceIP,
+				"resolver":         configMonitor.Resolver,
+				"g_rpc_service":    configMonitor.GRPCService, // GORM's names for the GRPC columns
+				"g_rpc_tls":        configMonitor.GRPCTLS,
+				"insecure_tls":     configMonitor.InsecureTLS,
+				"client_cert_path": configMonitor.ClientCertPath,
+				"client_key_path":  configMonitor.ClientKeyPath,
+				"user_agent":       configMonitor.UserAgent,
 				"dns_type":         configMonitor.DNSType,
+				"record_priority":  configMonitor.RecordPriority,
+				"record_weight":    configMonitor.RecordWeight,
+				"record_port":      configMonitor.RecordPort,
 				"interval":         configMonitor.Interval,
 				"timeout":          configMonitor.Timeout,
 				"retries":          configMonitor.Retries,
 				"recovery_retries": configMonitor.RecoveryRetries,
+				"max_packet_loss":  configMonitor.MaxPacketLoss,
+				"max_latency_ms":   configMonitor.MaxLatencyMs,
+				"ping_attempts":    configMonitor.PingAttempts,
+				"ping_packets":     configMonitor.PingPackets,
+				"ping_delay":       configMonitor.PingDelay,
 				"original_ip":      configMonitor.OriginalIP,
 				"backup_ip":        configMonitor.BackupIP,
 				"cf_zone_id":       configMonitor.CFZoneID,
-				"cf_record_id":     configMonitor.CFRecordID,
+				"cf_record_id":     recordID,
 				"cf_domain":        configMonitor.CFDomain,
-			})
+				"cf_api_token":     configMonitor.CFApiToken,
+				"webhook_secret":   configMonitor.WebhookSecret,
+				"public":           configMonitor.Public,
+				"check_mode":       configMonitor.CheckMode,
+				"check_backup":     configMonitor.CheckBackup,
+
+				"failover_cooldown":   configMonitor.FailoverCooldown,
+				"notify_on_failover":  configMonitor.NotifyOnFailover,
+				"notify_on_recovery":  configMonitor.NotifyOnRecovery,
+				"notify_on_scheduled": configMonitor.NotifyOnScheduled,
+				"promote_backups":     configMonitor.PromoteBackups,
+
+				"expected_content_type": configMonitor.ExpectedContentType,
+				"min_response_bytes":    configMonitor.MinResponseBytes,
+				"update_mode":           configMonitor.UpdateMode,
+				"lb_account_id":         configMonitor.LBAccountID,
+				"lb_pool_id":            configMonitor.LBPoolID,
+				"window_days":           configMonitor.WindowDays,
+				"window_start":          configMonitor.WindowStart,
+				"window_end":            configMonitor.WindowEnd,
+				"window_timezone":       configMonitor.WindowTimezone,
+				"failover_after":        configMonitor.FailoverAfter,
+			}).Error
+			if err != nil {
+				slog.Error("Failed to sync monitor", "monitor_id", existing.ID, "monitor", existing.Name, "error", err)
+			}
 
 			// Sync Schedules
 			DB.Where("monitor_id = ?", existing.ID).Delete(&Schedule{})
 			for _, sc := range mc.Schedules {
 				s := Schedule{
-					MonitorID: existing.ID,
-					Cron:      sc.Cron,
-					TargetIP:  sc.TargetIP,
+					MonitorID:   existing.ID,
+					Cron:        sc.Cron,
+					TargetIP:    sc.TargetIP,
+					RevertAfter: sc.RevertAfter,
 				}
 				DB.Create(&s)
 			}
 
+			// Sync extra records
+			syncRecords(existing.ID, oldZoneID, configMonitor.CFZoneID, configMonitor.Records)
+
+			// Sync extra backups
+			DB.Where("monitor_id = ?", existing.ID).Delete(&MonitorBackup{})
+			for _, b := range configMonitor.Backups {
+				b.MonitorID = existing.ID
+				DB.Create(&b)
+			}
+
+			// Sync extra checks
+			DB.Where("monitor_id = ?", existing.ID).Delete(&MonitorCheck{})
+			for _, mc := range configMonitor.Checks {
+				mc.MonitorID = existing.ID
+				DB.Create(&mc)
+			}
+			unlock()
+			updated = append(updated, existing.Name)
+
+			if actor != "" {
+				var after Monitor
+				DB.First(&after, existing.ID)
+				changes := append(ConfigChanges(before, after), syncListChanges(before, mc)...)
+				if len(changes) > 0 {
+					NotifyConfigChange(NotifyMonitorUpdated, &after, actor, changes)
+				}
+			}
+
 		} else {
 			// Not Found: Create New
 			// Set initial state
@@ -119,14 +227,303 @@ func SeedMonitors() {
 
 			for _, sc := range mc.Schedules {
 				s := Schedule{
-					MonitorID: configMonitor.ID,
-					Cron:      sc.Cron,
-					TargetIP:  sc.TargetIP,
+					MonitorID:   configMonitor.ID,
+					Cron:        sc.Cron,
+					TargetIP:    sc.TargetIP,
+					RevertAfter: sc.RevertAfter,
 				}
 				DB.Create(&s)
 			}
 			log.Printf("Created new monitor: %s", configMonitor.Name)
+			created = append(created, configMonitor.Name)
+			if actor != "" {
+				NotifyConfigChange(NotifyMonitorCreated, &configMonitor, actor, nil)
+			}
+		}
+	}
+	return created, updated
+}
+
+// syncListChanges names the lists a sync replaces whose contents differ
+// between the stored monitor and the config, as "schedules: replaced" like
+// UpdateMonitor reports them. Looked-up record IDs don't count as a change.
+func syncListChanges(existing Monitor, mc MonitorConfig) []string {
+	var changes []string
+	var schedules []ScheduleConfig
+	for _, s := range existing.Schedules {
+		schedules = append(schedules, ScheduleConfig{Cron: s.Cron, TargetIP: s.TargetIP, RevertAfter: s.RevertAfter})
+	}
+	if !slices.Equal(schedules, mc.Schedules) {
+		changes = append(changes, "schedules: replaced")
+	}
+	var records, configRecords []RecordConfig
+	for _, r := range existing.Records {
+		records = append(records, RecordConfig{Domain: r.Domain, ZoneID: r.ZoneID})
+	}
+	for _, rc := range mc.Records {
+		configRecords = append(configRecords, RecordConfig{Domain: rc.Domain, ZoneID: rc.ZoneID})
+	}
+	if !slices.Equal(records, configRecords) {
+		changes = append(changes, "records: replaced")
+	}
+	var backups []BackupConfig
+	for _, b := range existing.Backups {
+		backups = append(backups, BackupConfig{IP: b.IP, Priority: b.Priority})
+	}
+	if !slices.Equal(backups, mc.Backups) {
+		changes = append(changes, "backups: replaced")
+	}
+	var checks []CheckConfig
+	for _, c := range existing.Checks {
+		checks = append(checks, CheckConfig{Type: c.Type, Target: c.Target, Port: c.Port})
+	}
+	if !slices.Equal(checks, mc.Checks) {
+		changes = append(changes, "checks: replaced")
+	}
+	return changes
+}
+
+// syncRecords makes a monitor's extra records match the config, matched by
+// domain, so record IDs looked up earlier survive restarts and reseeds. A
+// stored ID is dropped when the config sets another one or the record's zone
+// changes; oldZoneID and zoneID are the monitor's zone before and after.
+func syncRecords(monitorID uint, oldZoneID, zoneID string, records []MonitorRecord) {
+	var existing []MonitorRecord
+	DB.Where("monitor_id = ?", monitorID).Find(&existing)
+	byDomain := make(map[string][]MonitorRecord, len(existing))
+	for _, r := range existing {
+		byDomain[r.Domain] = append(byDomain[r.Domain], r)
+	}
+
+	effectiveZone := func(r MonitorRecord, monitorZone string) string {
+		if r.ZoneID != "" {
+			return r.ZoneID
+		}
+		return monitorZone
+	}
+	for _, r := range records {
+		r.MonitorID = monitorID
+		if len(byDomain[r.Domain]) == 0 {
+			DB.Create(&r)
+			continue
+		}
+		old := byDomain[r.Domain][0]
+		byDomain[r.Domain] = byDomain[r.Domain][1:]
+		r.ID = old.ID
+		if r.RecordID == "" && effectiveZone(r, zoneID) == effectiveZone(old, oldZoneID) {
+			r.RecordID = old.RecordID
+		}
+		DB.Save(&r)
+	}
+	for _, stale := range byDomain {
+		for _, r := range stale {
+			DB.Delete(&r)
+		}
+	}
+}
+
+// SeedAdminUser creates the initial admin on first run (empty user table).
+// Without an explicit admin_password the JWT secret is used, which keeps
+// existing single-secret deployments able to log in as "admin".
+func SeedAdminUser() {
+	var count int64
+	DB.Model(&User{}).Count(&count)
+	if count > 0 {
+		return
+	}
+
+	server := GetConfig().Server
+	username := server.AdminUsername
+	if username == "" {
+		username = "admin"
+	}
+	password := server.AdminPassword
+	if password == "" {
+		password = server.JwtSecret
+	}
+	if password == "" {
+		slog.Warn("No admin_password or jwt_secret configured, skipping initial admin creation")
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatalf("Failed to hash admin password: %v", err)
+	}
+	if err := DB.Create(&User{Username: username, PasswordHash: string(hash), Role: RoleAdmin}).Error; err != nil {
+		log.Fatalf("Failed to create admin user: %v", err)
+	}
+	slog.Info("Created initial admin user", "username", username)
+}
+
+// Settings persisted by the first-run setup (POST /api/setup)
+const (
+	setupCompleteKey  = "setup_complete"
+	setupJwtSecretKey = "jwt_secret"
+	setupAccountKey   = "setup_account"
+)
+
+// NeedSetup reports whether the first-run setup is still open: jwt_secret is
+// empty or a placeholder and setup hasn't been completed.
+func NeedSetup() bool {
+	if secret := GetConfig().Server.JwtSecret; secret != "" && !isDefaultJwtSecret(secret) {
+		return false
+	}
+	return GetGlobalConfig(setupCompleteKey) != "true"
+}
+
+// ApplySetupConfig loads what the first-run setup saved. A real jwt_secret
+// in config.yaml, and a config.yaml account with credentials under the same
+// name, take precedence.
+func ApplySetupConfig() {
+	UpdateConfig(applySetupConfig)
+}
+
+// applySetupConfig is ApplySetupConfig for a config not yet published.
+func applySetupConfig(cfg *Config) {
+	if secret := GetGlobalConfig(setupJwtSecretKey); secret != "" {
+		if cfg.Server.JwtSecret == "" || isDefaultJwtSecret(cfg.Server.JwtSecret) {
+			cfg.Server.JwtSecret = secret
+		}
+	}
+	if raw := GetGlobalConfig(setupAccountKey); raw != "" {
+		var acc AccountConfig
+		if err := json.Unmarshal([]byte(raw), &acc); err != nil {
+			slog.Warn("Ignoring invalid setup account", "error", err)
+			return
+		}
+		addSetupAccount(cfg, acc)
+	}
+}
+
+// addSetupAccount adds acc to cfg's accounts, replacing a same-named
+// placeholder from the example config. The accounts are copied first, as
+// cfg may share them with the published config.
+func addSetupAccount(cfg *Config, acc AccountConfig) {
+	cfg.Accounts = slices.Clone(cfg.Accounts)
+	for i := range cfg.Accounts {
+		if cfg.Accounts[i].Name != acc.Name {
+			continue
+		}
+		if !cfg.Accounts[i].hasCredentials() {
+			cfg.Accounts[i] = acc
+		}
+		return
+	}
+	cfg.Accounts = append(cfg.Accounts, acc)
+}
+
+// BackupDatabase writes a consistent snapshot of the SQLite database to dst,
+// which must not exist or be empty. VACUUM INTO reads through a normal
+// transaction, so it is safe while the app keeps writing in WAL mode.
+func BackupDatabase(dst string) error {
+	return DB.Exec("VACUUM INTO ?", dst).Error
+}
+
+// PruneHistory deletes check results and events older than the configured
+// retention. A retention of 0 keeps everything.
+func PruneHistory() {
+	prune := func(model interface{}, days int, what string) {
+		if days <= 0 {
+			return
+		}
+		cutoff := time.Now().AddDate(0, 0, -days)
+		res := DB.Where("created_at < ?", cutoff).Delete(model)
+		if res.Error != nil {
+			slog.Error("Failed to prune history", "table", what, "error", res.Error)
+			return
+		}
+		if res.RowsAffected > 0 {
+			slog.Info("Pruned history", "table", what, "rows", res.RowsAffected, "older_than_days", days)
+		}
+	}
+	db := GetConfig().Database
+	prune(&CheckResult{}, db.HistoryRetentionDays, "check results")
+	prune(&Event{}, db.EventRetentionDays, "events")
+}
+
+// PurgeDeletedMonitors permanently removes monitors deleted more than
+// trash_retention_days ago, with their schedules, records, checks and check
+// history. Their events stay in the log.
+func PurgeDeletedMonitors() {
+	days := GetConfig().Database.TrashRetentionDays
+	if days <= 0 {
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	var ids []uint
+	DB.Unscoped().Model(&Monitor{}).Where("deleted_at < ?", cutoff).Pluck("id", &ids)
+	for _, id := range ids {
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			for _, model := range []interface{}{&Schedule{}, &MonitorRecord{}, &MonitorBackup{}, &MonitorCheck{}, &CheckResult{}} {
+				if err := tx.Where("monitor_id = ?", id).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Unscoped().Delete(&Monitor{}, id).Error
+		})
+		if err != nil {
+			slog.Error("Failed to purge deleted monitor", "monitor_id", id, "error", err)
+			continue
 		}
+		slog.Info("Purged deleted monitor", "monitor_id", id, "deleted_more_than_days", days)
+	}
+}
+
+// VacuumDatabase rebuilds the SQLite file to reclaim space freed by pruning.
+func VacuumDatabase() {
+	if DB.Dialector.Name() != "sqlite" {
+		return
+	}
+	if err := DB.Exec("VACUUM").Error; err != nil {
+		slog.Error("VACUUM failed", "error", err)
+	}
+}
+
+// UptimeSince returns the percentage of successful checks per monitor since
+// the given time. Monitors without checks in the window are absent.
+func UptimeSince(since time.Time) map[uint]float64 {
+	var rows []struct {
+		MonitorID uint
+		Uptime    float64
+	}
+	DB.Model(&CheckResult{}).
+		Select("monitor_id, AVG(CASE WHEN up THEN 100.0 ELSE 0 END) AS uptime").
+		Where("created_at >= ?", since).
+		Group("monitor_id").
+		Scan(&rows)
+
+	uptime := make(map[uint]float64, len(rows))
+	for _, r := range rows {
+		uptime[r.MonitorID] = r.Uptime
+	}
+	return uptime
+}
+
+// GetGlobalConfig returns a persisted runtime setting, or "" if unset.
+func GetGlobalConfig(key string) string {
+	var gc GlobalConfig
+	if err := DB.Where(&GlobalConfig{Key: key}).First(&gc).Error; err != nil {
+		return ""
+	}
+	return gc.Value
+}
+
+func SetGlobalConfig(key, value string) error {
+	return DB.Save(&GlobalConfig{Key: key, Value: value}).Error
+}
+
+// RecordCheck appends a health check result to the check history.
+func RecordCheck(r CheckResult) {
+	if err := DB.Create(&r).Error; err != nil {
+		slog.Error("Failed to record check", "monitor_id", r.MonitorID, "error", err)
+	}
+}
+
+// RecordEvent appends an entry to the event log. Failures are logged, never fatal.
+func RecordEvent(e Event) {
+	if err := DB.Create(&e).Error; err != nil {
+		slog.Error("Failed to record event", "monitor_id", e.MonitorID, "type", e.Type, "error", err)
 	}
-	log.Println("Monitor sync complete.")
 }
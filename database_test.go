@@ -0,0 +1,183 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testMonitorConfig() MonitorConfig {
+	return MonitorConfig{
+		Name:       "web",
+		Account:    "default",
+		Domain:     "web.example.com",
+		ZoneID:     "023e105f4ecef8ad9ca31a8372d0c353",
+		Type:       "ping",
+		Target:     "1.1.1.1",
+		OriginalIP: "1.1.1.1",
+		BackupIP:   "2.2.2.2",
+	}
+}
+
+func TestSyncMonitorsUpdatesExisting(t *testing.T) {
+	setupTestDB(t)
+	mc := testMonitorConfig()
+	SyncMonitors([]MonitorConfig{mc}, "")
+
+	mc.Domain = "www.example.com"
+	mc.Type = "grpc"
+	mc.Target = "backend.example.com:50051"
+	mc.GRPCService = "health"
+	mc.GRPCTLS = true
+	created, updated := SyncMonitors([]MonitorConfig{mc}, "")
+	if len(created) != 0 || len(updated) != 1 {
+		t.Fatalf("created %v, updated %v; want one update", created, updated)
+	}
+
+	var got Monitor
+	DB.Where("name = ?", "web").First(&got)
+	if got.CFDomain != "www.example.com" || got.Type != "grpc" || got.GRPCService != "health" || !got.GRPCTLS {
+		t.Errorf("config not synced: domain=%s type=%s grpc_service=%s grpc_tls=%v", got.CFDomain, got.Type, got.GRPCService, got.GRPCTLS)
+	}
+}
+
+func testMonitorConfigWithRecords() MonitorConfig {
+	mc := testMonitorConfig()
+	mc.Records = []RecordConfig{
+		{Domain: "api.example.com"},
+		{Domain: "cdn.example.com"},
+	}
+	return mc
+}
+
+// storeLookedUpIDs stands in for record ID lookups done since the last sync.
+func storeLookedUpIDs(t *testing.T) Monitor {
+	t.Helper()
+	var m Monitor
+	if err := DB.Preload("Records").Where("name = ?", "web").First(&m).Error; err != nil {
+		t.Fatalf("monitor not synced: %v", err)
+	}
+	DB.Model(&Monitor{}).Where("id = ?", m.ID).Update("cf_record_id", "rec-web")
+	for _, r := range m.Records {
+		DB.Model(&MonitorRecord{}).Where("id = ?", r.ID).Update("record_id", "rec-"+r.Domain)
+	}
+	return m
+}
+
+func syncedRecords(m Monitor) map[string]MonitorRecord {
+	var records []MonitorRecord
+	DB.Where("monitor_id = ?", m.ID).Find(&records)
+	byDomain := map[string]MonitorRecord{}
+	for _, r := range records {
+		byDomain[r.Domain] = r
+	}
+	return byDomain
+}
+
+func TestSyncMonitorsKeepsRecordIDs(t *testing.T) {
+	setupTestDB(t)
+	mc := testMonitorConfigWithRecords()
+	SyncMonitors([]MonitorConfig{mc}, "")
+	m := storeLookedUpIDs(t)
+	before := syncedRecords(m)
+
+	SyncMonitors([]MonitorConfig{mc}, "")
+
+	var got Monitor
+	DB.First(&got, m.ID)
+	if got.CFRecordID != "rec-web" {
+		t.Errorf("monitor record id = %q, want rec-web", got.CFRecordID)
+	}
+	after := syncedRecords(m)
+	if len(after) != 2 {
+		t.Fatalf("%d records after sync, want 2", len(after))
+	}
+	for domain, r := range after {
+		if r.ID != before[domain].ID || r.RecordID != "rec-"+domain {
+			t.Errorf("%s: row %d id %q, want row %d id %q", domain, r.ID, r.RecordID, before[domain].ID, "rec-"+domain)
+		}
+	}
+}
+
+func TestSyncMonitorsDropsRecordIDsOnChange(t *testing.T) {
+	setupTestDB(t)
+	mc := testMonitorConfigWithRecords()
+	SyncMonitors([]MonitorConfig{mc}, "")
+	m := storeLookedUpIDs(t)
+
+	// New main domain, one record moved to another zone, one removed and
+	// one added
+	mc.Domain = "www.example.com"
+	mc.Records = []RecordConfig{
+		{Domain: "api.example.com", ZoneID: "17b5962d775c646f3f9725cbc7a53df4"},
+		{Domain: "img.example.com"},
+	}
+	SyncMonitors([]MonitorConfig{mc}, "")
+
+	var got Monitor
+	DB.First(&got, m.ID)
+	if got.CFRecordID != "" {
+		t.Errorf("monitor record id = %q after domain change, want empty", got.CFRecordID)
+	}
+	after := syncedRecords(m)
+	if len(after) != 2 {
+		t.Fatalf("records = %v, want api and img", after)
+	}
+	if r := after["api.example.com"]; r.RecordID != "" {
+		t.Errorf("api record id = %q after zone change, want empty", r.RecordID)
+	}
+	if _, ok := after["img.example.com"]; !ok {
+		t.Error("img record not created")
+	}
+}
+
+func TestSyncMonitorsConfigRecordIDWins(t *testing.T) {
+	setupTestDB(t)
+	mc := testMonitorConfigWithRecords()
+	SyncMonitors([]MonitorConfig{mc}, "")
+	m := storeLookedUpIDs(t)
+
+	mc.Records[0].RecordID = "rec-pinned"
+	SyncMonitors([]MonitorConfig{mc}, "")
+
+	if r := syncedRecords(m)["api.example.com"]; r.RecordID != "rec-pinned" {
+		t.Errorf("api record id = %q, want rec-pinned", r.RecordID)
+	}
+}
+
+func TestSyncMonitorsDuplicateDomainsStayStable(t *testing.T) {
+	setupTestDB(t)
+	mc := testMonitorConfig()
+	mc.Records = []RecordConfig{{Domain: "api.example.com"}, {Domain: "api.example.com"}}
+	for i := 0; i < 3; i++ {
+		SyncMonitors([]MonitorConfig{mc}, "")
+	}
+
+	var count int64
+	DB.Model(&MonitorRecord{}).Count(&count)
+	if count != 2 {
+		t.Errorf("%d records after repeated syncs, want 2", count)
+	}
+}
+
+func TestSyncMonitorsNotifiesChanges(t *testing.T) {
+	setupTestDB(t)
+	web, api := testMonitorConfig(), testMonitorConfig()
+	api.Name = "api"
+	SyncMonitors([]MonitorConfig{web, api}, "")
+	notifications := captureNotifications(t)
+
+	// Only the monitor whose config changed, and the new one, are reported
+	web.Interval = 30
+	api.Schedules = nil
+	db := testMonitorConfig()
+	db.Name = "db"
+	SyncMonitors([]MonitorConfig{web, api, db}, "admin")
+
+	got := notifications(3)
+	slices.Sort(got)
+	if len(got) != 2 || !strings.HasSuffix(got[0], "admin created monitor db") ||
+		!strings.Contains(got[1], "admin changed monitor web") || !strings.Contains(got[1], "interval: 60 -> 30") {
+		t.Errorf("notifications = %q, want db created and web's interval", got)
+	}
+}
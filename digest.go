@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// --- Notification Digest ---
+
+// QuietHours holds notifications during a daily window and sends them as one
+// digest when the window ends. Only delivery is delayed: monitors keep
+// switching DNS as usual.
+type QuietHours struct {
+	Enabled  bool     `yaml:"enabled"`
+	Start    string   `yaml:"start"`    // "23:00"
+	End      string   `yaml:"end"`      // "07:00", may be past midnight
+	Timezone string   `yaml:"timezone"` // IANA name, empty = server local time
+	Bypass   []string `yaml:"bypass"`   // Severities sent right away: info, recovery, failover
+
+	loc        *time.Location
+	start, end int // Minutes since midnight
+}
+
+var severityNames = map[string]notifySeverity{
+	"info":     severityInfo,
+	"recovery": severityRecovery,
+	"failover": severityFailover,
+}
+
+// init parses the window. Called once from LoadConfig.
+func (q *QuietHours) init() error {
+	if !q.Enabled {
+		return nil
+	}
+	var err error
+	if q.start, err = parseClock(q.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if q.end, err = parseClock(q.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if q.start == q.end {
+		return fmt.Errorf("start and end must differ")
+	}
+	q.loc = time.Local
+	if q.Timezone != "" {
+		if q.loc, err = time.LoadLocation(q.Timezone); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+	}
+	for _, s := range q.Bypass {
+		if _, ok := severityNames[s]; !ok {
+			return fmt.Errorf("unknown bypass severity %q", s)
+		}
+	}
+	return nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// windowEnd returns when the quiet window containing t ends, or the zero
+// time if t is outside the window.
+func (q *QuietHours) windowEnd(t time.Time) time.Time {
+	if !q.Enabled || q.loc == nil {
+		return time.Time{}
+	}
+	t = t.In(q.loc)
+	now := t.Hour()*60 + t.Minute()
+	inside := now >= q.start && now < q.end
+	if q.start > q.end {
+		inside = now >= q.start || now < q.end
+	}
+	if !inside {
+		return time.Time{}
+	}
+	end := time.Date(t.Year(), t.Month(), t.Day(), q.end/60, q.end%60, 0, 0, q.loc)
+	if !end.After(t) {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, q.end/60, q.end%60, 0, 0, q.loc)
+	}
+	return end
+}
+
+func (q *QuietHours) bypasses(sev notifySeverity) bool {
+	for _, s := range q.Bypass {
+		if severityNames[s] == sev {
+			return true
+		}
+	}
+	return false
+}
+
+// Held notifications live in memory only and are lost on restart.
+var (
+	digestMu    sync.Mutex
+	digestQueue []NotifyEvent
+	digestTimer *time.Timer
+)
+
+// holdNotification queues ev when quiet hours are active and its severity
+// doesn't bypass them. It reports whether the event was held.
+func holdNotification(ev NotifyEvent) bool {
+	q := &GetConfig().Notification.QuietHours
+	end := q.windowEnd(ev.Time)
+	if end.IsZero() || q.bypasses(ev.Severity()) {
+		return false
+	}
+
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	digestQueue = append(digestQueue, ev)
+	if digestTimer == nil {
+		digestTimer = time.AfterFunc(time.Until(end), flushDigest)
+	}
+	slog.Info("Notification held for quiet hours", "kind", ev.Kind, "monitor", ev.Monitor, "until", end)
+	return true
+}
+
+// flushDigest sends everything held so far: a single event as is, several
+// as one digest.
+func flushDigest() {
+	digestMu.Lock()
+	events := digestQueue
+	digestQueue = nil
+	digestTimer = nil
+	digestMu.Unlock()
+
+	switch len(events) {
+	case 0:
+		return
+	case 1:
+		dispatchNotification(events[0])
+	default:
+		dispatchNotification(NotifyEvent{Kind: NotifyDigest, Events: events, Time: time.Now()})
+	}
+}
+
+// Failover/recovery bursts: once batch.min_count events arrive within
+// batch.window, later ones are queued until the window closes and sent as
+// one message. Sparse events go out individually as before.
+var (
+	batchMu     sync.Mutex
+	batchRecent []time.Time // Failover/recovery times within the last window
+	batchQueue  []NotifyEvent
+	batchTimer  *time.Timer
+)
+
+// batchNotification queues ev when it is part of a burst. It reports whether
+// the event was queued.
+func batchNotification(ev NotifyEvent) bool {
+	conf := GetConfig().Notification.Batch
+	if !conf.Enabled || (ev.Kind != NotifyFailover && ev.Kind != NotifyRecovery) {
+		return false
+	}
+	window := time.Duration(conf.Window) * time.Second
+
+	batchMu.Lock()
+	defer batchMu.Unlock()
+
+	cutoff := ev.Time.Add(-window)
+	i := 0
+	for i < len(batchRecent) && batchRecent[i].Before(cutoff) {
+		i++
+	}
+	batchRecent = append(batchRecent[i:], ev.Time)
+	if batchTimer == nil && len(batchRecent) < conf.MinCount {
+		return false
+	}
+
+	batchQueue = append(batchQueue, ev)
+	if batchTimer == nil {
+		batchTimer = time.AfterFunc(window, flushBatch)
+		slog.Info("Notification burst, batching until window ends", "events", len(batchRecent), "window", window)
+	}
+	return true
+}
+
+func flushBatch() {
+	batchMu.Lock()
+	events := batchQueue
+	batchQueue = nil
+	batchTimer = nil
+	batchMu.Unlock()
+
+	switch len(events) {
+	case 0:
+		return
+	case 1:
+		dispatchNotification(events[0])
+	default:
+		dispatchNotification(NotifyEvent{Kind: NotifyBatch, Events: events, Time: time.Now()})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// --- DNS Providers ---
+
+// DNSRecord is one record a monitor switches, with the type-specific fields
+// written along with its content.
+type DNSRecord struct {
+	ZoneID   string
+	RecordID string
+	Domain   string
+	Type     string // A (default), AAAA, CNAME, MX, SRV
+	Priority int    // MX, SRV
+	Weight   int    // SRV
+	Port     int    // SRV
+}
+
+// DNSProvider is the DNS API behind an account. The monitor engine reaches
+// records only through it, so a new provider needs no failover changes.
+type DNSProvider interface {
+	// UpdateRecord points the record at content: an IP, or the target
+	// hostname for CNAME/MX/SRV.
+	UpdateRecord(ctx context.Context, rec DNSRecord, content string) DNSUpdateResult
+	// GetRecordID looks up the record with rec's zone, name and type, and
+	// returns errRecordNotFound when there is none.
+	GetRecordID(ctx context.Context, rec DNSRecord) (string, error)
+	// GetRecord reads the record (by ID) as the provider serves it now. Its
+	// Content is comparable with CurrentIP: the target hostname for SRV.
+	GetRecord(ctx context.Context, rec DNSRecord) (DNSRecordInfo, error)
+}
+
+const defaultDNSProvider = "cloudflare"
+
+// dnsProviders builds an account's provider, keyed by accounts[].provider.
+var dnsProviders = map[string]func(acc *AccountConfig) DNSProvider{
+	"cloudflare": func(acc *AccountConfig) DNSProvider { return cloudflareProvider{acc: acc} },
+}
+
+// GetDNSProvider returns the provider serving acc.
+func GetDNSProvider(acc *AccountConfig) (DNSProvider, error) {
+	newProvider, ok := dnsProviders[acc.ProviderName()]
+	if !ok {
+		return nil, fmt.Errorf("account %q: unknown provider %q, valid providers: %s", acc.Name, acc.Provider, strings.Join(dnsProviderNames(), ", "))
+	}
+	return newProvider(acc), nil
+}
+
+func dnsProviderNames() []string {
+	names := make([]string, 0, len(dnsProviders))
+	for name := range dnsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateAccountProviders rejects accounts naming a provider that doesn't exist.
+func validateAccountProviders(accounts []AccountConfig) error {
+	for i := range accounts {
+		if _, err := GetDNSProvider(&accounts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dnsRecord describes one of the monitor's records for its provider.
+func (m *Monitor) dnsRecord(zoneID, recordID, domain string) DNSRecord {
+	return DNSRecord{
+		ZoneID:   zoneID,
+		RecordID: recordID,
+		Domain:   domain,
+		Type:     m.DNSType,
+		Priority: m.RecordPriority,
+		Weight:   m.RecordWeight,
+		Port:     m.RecordPort,
+	}
+}
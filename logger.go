@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Logging ---
+
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "info":
+		return slog.LevelInfo
+	}
+	// Fallback to the legacy Debug flag when no level is configured
+	if GetConfig().Server.Debug {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+// textLogger is slog's initial default, which writes through the standard
+// logger. Kept to switch back from JSON when the config is reloaded.
+var textLogger = slog.Default()
+
+func InitLogger() {
+	server := GetConfig().Server
+	level := parseLogLevel(server.LogLevel)
+
+	if strings.ToLower(server.LogFormat) == "json" {
+		// JSON output for log shippers (Loki/ELK).
+		// Remaining log.Printf calls are routed through this handler as well.
+		handler := slog.NewJSONHandler(io.MultiWriter(os.Stdout, recentLogs), &slog.HandlerOptions{Level: level})
+		slog.SetDefault(slog.New(handler))
+		return
+	}
+
+	// Text: keep the default human-readable output of the standard logger,
+	// which slog writes through too. Setting the default back doesn't undo
+	// what a JSON setup did to the standard logger, so restore that as well.
+	slog.SetDefault(textLogger)
+	slog.SetLogLoggerLevel(level)
+	log.SetFlags(log.LstdFlags)
+	log.SetOutput(io.MultiWriter(os.Stderr, recentLogs))
+}
+
+// Lines kept for GET /api/logs
+const logBufferSize = 1000
+
+// LogEntry is one captured log line.
+type LogEntry struct {
+	Time      time.Time  `json:"time"`
+	Level     slog.Level `json:"level"`
+	MonitorID uint       `json:"monitor_id,omitempty"`
+	Line      string     `json:"line"`
+}
+
+// logBuffer keeps the most recent log lines in memory, so they can be read
+// from the dashboard without shell access. It sits behind the logger's
+// writer rather than wrapping the slog handler: the default text handler
+// writes through the standard logger, and wrapping it would recurse.
+type logBuffer struct {
+	mu      sync.Mutex
+	entries []LogEntry // Ring of logBufferSize, next points at the oldest
+	next    int
+}
+
+var recentLogs = &logBuffer{}
+
+var (
+	textLogLevel     = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} (DEBUG|INFO|WARN|ERROR) `)
+	textLogMonitorID = regexp.MustCompile(`\bmonitor_id=(\d+)`)
+)
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	now := time.Now()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line != "" {
+			b.add(parseLogLine(now, line))
+		}
+	}
+	return len(p), nil
+}
+
+// parseLogLine reads the level and monitor_id of a JSON or text log line.
+// Lines from log.Printf carry no level and count as INFO.
+func parseLogLine(now time.Time, line string) LogEntry {
+	e := LogEntry{Time: now, Level: slog.LevelInfo, Line: line}
+	var fields struct {
+		Level     slog.Level `json:"level"`
+		MonitorID uint       `json:"monitor_id"`
+	}
+	if strings.HasPrefix(line, "{") && json.Unmarshal([]byte(line), &fields) == nil {
+		e.Level, e.MonitorID = fields.Level, fields.MonitorID
+		return e
+	}
+	if m := textLogLevel.FindStringSubmatch(line); m != nil {
+		e.Level.UnmarshalText([]byte(m[1]))
+	}
+	if m := textLogMonitorID.FindStringSubmatch(line); m != nil {
+		id, _ := strconv.ParseUint(m[1], 10, 64)
+		e.MonitorID = uint(id)
+	}
+	return e
+}
+
+func (b *logBuffer) add(e LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.entries) < logBufferSize {
+		b.entries = append(b.entries, e)
+		return
+	}
+	b.entries[b.next] = e
+	b.next = (b.next + 1) % logBufferSize
+}
+
+// Recent returns up to n of the newest entries at or above minLevel, oldest
+// first, optionally only those of one monitor (monitorID 0 = all).
+func (b *logBuffer) Recent(n int, minLevel slog.Level, monitorID uint) []LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := []LogEntry{}
+	for i := len(b.entries) - 1; i >= 0 && len(out) < n; i-- {
+		e := b.entries[(b.next+i)%len(b.entries)]
+		if e.Level >= minLevel && (monitorID == 0 || e.MonitorID == monitorID) {
+			out = append(out, e)
+		}
+	}
+	slices.Reverse(out)
+	return out
+}
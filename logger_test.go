@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func TestInitLoggerJSONToText(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Server.LogFormat = "json"
+	SetConfig(&cfg)
+	InitLogger()
+
+	cfg.Server.LogFormat = "text"
+	SetConfig(&cfg)
+	InitLogger()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	slog.Warn("Reloaded", "monitor_id", 3)
+	line := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} WARN Reloaded monitor_id=3\n$`)
+	if !line.MatchString(buf.String()) {
+		t.Errorf("text log line = %q", buf.String())
+	}
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"context"
 	"embed"
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,18 +23,56 @@ import (
 //go:embed static
 var embedFS embed.FS
 
+// appCtx lives as long as the process and is cancelled on shutdown, once
+// the grace period is over or nothing is running any more, so outbound
+// calls can't hold up exit.
+var appCtx, stopApp = context.WithCancel(context.Background())
+
 // --- Main ---
 
 func main() {
-	LoadConfig()
+	configPath := flag.String("config", os.Getenv("CFGUARD_CONFIG"), "path to the config file (env CFGUARD_CONFIG, default ./config.yaml)")
+	flag.Parse()
+
+	LoadConfig(*configPath)
+	InitLogger()
 	InitDB()
+	ApplySetupConfig()
+	CheckSecurityConfig()
+
+	// Schema downgrade before running an older release: cfguard migrate-down <version>
+	if args := flag.Args(); len(args) == 2 && args[0] == "migrate-down" {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("Invalid migration version: %s", args[1])
+		}
+		if err := RollbackMigrations(version); err != nil {
+			log.Fatalf("Rollback failed: %v", err)
+		}
+		return
+	}
+
+	SeedAdminUser()
 	SeedMonitors()
 
-	if !AppConfig.Server.Debug {
+	if parseLogLevel(GetConfig().Server.LogLevel) > slog.LevelDebug {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
+	r := gin.New()
+	// X-Forwarded-For is only believed from the listed proxies; otherwise the
+	// client IP is the connection's peer address
+	if err := r.SetTrustedProxies(GetConfig().Server.TrustedProxies); err != nil {
+		log.Fatal("Invalid server.trusted_proxies: ", err)
+	}
+	if GetConfig().Server.AccessLog {
+		r.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+			SkipPaths: GetConfig().Server.AccessLogSkipPaths,
+		}))
+	}
+	r.Use(gin.Recovery())
+	r.Use(MetricsNetworks())
+	r.Use(RequestTimeout())
 
 	// Serve Static Files (Embedded)
 	staticFiles, err := fs.Sub(embedFS, "static")
@@ -51,30 +94,89 @@ func main() {
 
 	// API Routes
 	api := r.Group("/api")
+	api.Use(AllowedNetworks())
 	{
 		// Auth Routes
 		api.GET("/auth/check", AuthStatus)
 		api.POST("/auth/login", Login)
+		api.POST("/auth/logout", Logout)
+		api.POST("/setup", Setup)
+
+		// API Docs
+		api.GET("/openapi.json", func(c *gin.Context) {
+			c.FileFromFS("openapi.json", http.FS(staticFiles))
+		})
+		api.GET("/docs", func(c *gin.Context) {
+			c.FileFromFS("docs.html", http.FS(staticFiles))
+		})
+
+		// Public status page, enabled by server.public_status_enabled
+		api.GET("/public/status", GetPublicStatus)
+
+		// Incoming webhook from external monitoring, authenticated per monitor
+		api.POST("/monitors/:id/trigger", TriggerMonitor)
 
 		// Protected Routes
 		authorized := api.Group("/")
 		authorized.Use(AuthMiddleware())
 		{
 			authorized.GET("/monitors", GetMonitors)
-			authorized.POST("/monitors", CreateMonitor)
-			authorized.PUT("/monitors/:id", UpdateMonitor)
-			authorized.DELETE("/monitors/:id", DeleteMonitor)
-			authorized.POST("/monitors/:id/restore", RestoreMonitor)
+			authorized.GET("/monitors/trash", GetDeletedMonitors)
+			authorized.GET("/events", GetEvents)
+			authorized.GET("/stats", GetStats)
+			authorized.GET("/backup", RequireAdmin(), GetBackup)
+			authorized.POST("/config/reseed", RequireAdmin(), ReseedConfig)
+			authorized.GET("/config/raw", RequireAdmin(), GetRawConfig)
+			authorized.GET("/logs", RequireAdmin(), GetLogs)
+			authorized.POST("/config/raw", RequireAdmin(), UpdateRawConfig)
+			authorized.GET("/scheduler", GetSchedulerStatus)
+			authorized.POST("/scheduler/pause", RequireAdmin(), PauseScheduler)
+			authorized.POST("/scheduler/resume", RequireAdmin(), ResumeScheduler)
+			authorized.GET("/monitors/:id/checks", GetMonitorChecks)
+			authorized.GET("/monitors/:id/dns", GetMonitorDNS)
+			authorized.POST("/monitors", RequireAdmin(), CreateMonitor)
+			authorized.POST("/monitors/bulk", RequireAdmin(), BulkMonitors)
+			authorized.POST("/monitors/check-all", RequireAdmin(), CheckAllMonitors)
+			authorized.PUT("/monitors/:id", RequireAdmin(), UpdateMonitor)
+			authorized.DELETE("/monitors/:id", RequireAdmin(), DeleteMonitor)
+			authorized.POST("/monitors/:id/undelete", RequireAdmin(), UndeleteMonitor)
+			authorized.POST("/monitors/:id/restore", RequireAdmin(), RestoreMonitor)
+			authorized.POST("/monitors/:id/clone", RequireAdmin(), CloneMonitor)
+			authorized.POST("/monitors/:id/test-dns", RequireAdmin(), TestMonitorDNS)
+			authorized.POST("/monitors/:id/refresh-record-id", RequireAdmin(), RefreshMonitorRecordID)
+
+			authorized.GET("/accounts", GetAccounts)
+			authorized.GET("/cloudflare/zones", GetCloudflareZones)
+			authorized.GET("/cloudflare/records", GetCloudflareRecords)
+			authorized.POST("/cloudflare/verify", RequireAdmin(), VerifyCloudflare)
+
+			authorized.GET("/users", RequireAdmin(), GetUsers)
+			authorized.POST("/users", RequireAdmin(), CreateUser)
+			authorized.PUT("/users/:id", RequireAdmin(), UpdateUser)
+			authorized.DELETE("/users/:id", RequireAdmin(), DeleteUser)
 		}
 	}
 
+	if GetConfig().Server.ReconcileOnStartup {
+		ReconcileMonitors()
+	}
+
 	// Start Scheduler
 	StartScheduler()
+	if GetConfig().Server.CheckOnStartup {
+		StartStartupChecks()
+	}
 
-	addr := fmt.Sprintf(":%d", AppConfig.Server.Port)
+	// Verify Cloudflare credentials in the background so a bad token doesn't block startup
+	go VerifyCloudflareAccounts()
+
+	addr := fmt.Sprintf(":%d", GetConfig().Server.Port)
 	srv := &http.Server{
 		Addr:    addr,
 		Handler: r,
+		// Request contexts derive from appCtx, so shutdown also cancels
+		// Cloudflare calls made on behalf of API requests
+		BaseContext: func(net.Listener) context.Context { return appCtx },
 	}
 
 	// Initializing the server in a goroutine so that
@@ -86,8 +188,8 @@ func main() {
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
+	// Wait for interrupt signal to gracefully shutdown the server within
+	// server.shutdown_timeout.
 	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
@@ -96,16 +198,45 @@ func main() {
 	<-quit
 	log.Println("Shutting down server...")
 
-	// Stop Scheduler first to prevent new checks
-	StopScheduler()
-
-	// The context is used to inform the server it has 5 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Both phases below share one deadline
+	timeout := GetConfig().Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+
+	// Stop the scheduler and startup checks first to prevent new checks.
+	// Running ones may finish a failover in progress; past the deadline their
+	// Cloudflare calls are cancelled so they return promptly.
+	jobsDone := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			StopScheduler()
+		}()
+		go func() {
+			defer wg.Done()
+			StopStartupChecks()
+		}()
+		wg.Wait()
+		close(jobsDone)
+	}()
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		slog.Warn("Shutdown timeout reached while waiting for scheduler jobs and startup checks, cancelling them", "timeout", timeout)
+		stopApp()
+	}
+
+	// Then let the server finish the requests it is currently handling
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		stopApp()
+		log.Fatalf("Shutdown timeout (%s) reached while waiting for API requests: %v", timeout, err)
 	}
+	stopApp()
 
 	log.Println("Server exiting")
 }
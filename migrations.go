@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// --- Schema Migrations ---
+
+// SchemaMigration records an applied migration in the schema_migrations table.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migration is one numbered schema change. Up and Down run in a transaction.
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// migrations must stay ordered by version and are never edited once
+// released: fix a mistake with a new migration instead. Columns added to the
+// base tables are still handled by AutoMigrate in InitDB; new tables, indexes
+// and data changes go here.
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create check_results",
+		Up:      func(tx *gorm.DB) error { return tx.AutoMigrate(&CheckResult{}) },
+		Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&CheckResult{}) },
+	},
+	{
+		Version: 2,
+		Name:    "create monitor_records",
+		Up:      func(tx *gorm.DB) error { return tx.AutoMigrate(&MonitorRecord{}) },
+		Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&MonitorRecord{}) },
+	},
+	{
+		// GetMonitorChecks and pruning filter on both columns
+		Version: 3,
+		Name:    "index check_results by monitor and time",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("CREATE INDEX IF NOT EXISTS idx_check_results_monitor_created ON check_results (monitor_id, created_at)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("DROP INDEX IF EXISTS idx_check_results_monitor_created").Error
+		},
+	},
+	{
+		// The notify_on_* columns are added by AutoMigrate as false;
+		// monitors that existed before them keep notifying
+		Version: 4,
+		Name:    "enable notifications on existing monitors",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("UPDATE monitors SET notify_on_failover = true, notify_on_recovery = true, notify_on_scheduled = true").Error
+		},
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 5,
+		Name:    "create monitor_checks",
+		Up:      func(tx *gorm.DB) error { return tx.AutoMigrate(&MonitorCheck{}) },
+		Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&MonitorCheck{}) },
+	},
+	{
+		Version: 6,
+		Name:    "create monitor_backups",
+		Up:      func(tx *gorm.DB) error { return tx.AutoMigrate(&MonitorBackup{}) },
+		Down:    func(tx *gorm.DB) error { return tx.Migrator().DropTable(&MonitorBackup{}) },
+	},
+}
+
+func appliedMigrations() (map[int]bool, error) {
+	var rows []SchemaMigration
+	if err := DB.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool, len(rows))
+	for _, r := range rows {
+		applied[r.Version] = true
+	}
+	return applied, nil
+}
+
+// RunMigrations applies every pending migration in version order.
+func RunMigrations() error {
+	if err := DB.AutoMigrate(&SchemaMigration{}); err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	last := 0
+	for _, m := range migrations {
+		if m.Version <= last {
+			return fmt.Errorf("migration %d (%s) is out of order", m.Version, m.Name)
+		}
+		last = m.Version
+		if applied[m.Version] {
+			continue
+		}
+
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.Info("Applied migration", "version", m.Version, "name", m.Name)
+	}
+	return nil
+}
+
+// RollbackMigrations reverts applied migrations newer than version, newest first.
+func RollbackMigrations(version int) error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= version || !applied[m.Version] {
+			continue
+		}
+
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %d (%s): %w", m.Version, m.Name, err)
+		}
+		slog.Info("Rolled back migration", "version", m.Version, "name", m.Name)
+	}
+	return nil
+}
@@ -1,40 +1,183 @@
 package main
 
 import (
+	"fmt"
+	"mime"
+	"net"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // --- Models ---
 
 type Schedule struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	MonitorID   uint   `json:"monitor_id"`
+	Cron        string `json:"cron"`
+	TargetIP    string `json:"target_ip"`
+	RevertAfter string `json:"revert_after"` // Duration, e.g. "2h"; empty = stay on TargetIP
+}
+
+// MonitorRecord is an additional DNS record switched together with the
+// monitor's primary record (e.g. apex + www pointing at the same origin).
+type MonitorRecord struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	MonitorID uint   `gorm:"index" json:"monitor_id"`
+	ZoneID    string `json:"cf_zone_id"` // Empty = monitor's zone
+	RecordID  string `json:"cf_record_id"`
+	Domain    string `json:"cf_domain"`
+}
+
+// MonitorBackup is an additional failover target. On failover the healthy
+// backup with the lowest Priority wins; BackupIP itself has priority 0.
+type MonitorBackup struct {
 	ID        uint   `gorm:"primaryKey" json:"id"`
-	MonitorID uint   `json:"monitor_id"`
-	Cron      string `json:"cron"`
-	TargetIP  string `json:"target_ip"`
+	MonitorID uint   `gorm:"index" json:"monitor_id"`
+	IP        string `json:"ip"`
+	Priority  int    `json:"priority"` // Lower is preferred
+}
+
+// MonitorCheck is an additional health check combined with the monitor's own
+// check according to Monitor.CheckMode. Unlike the main check it probes its
+// target directly instead of going through OriginalIP.
+type MonitorCheck struct {
+	ID        uint   `gorm:"primaryKey" json:"id"`
+	MonitorID uint   `gorm:"index" json:"monitor_id"`
+	Type      string `json:"type"` // Empty = monitor's type
+	Target    string `json:"target"`
+	Port      int    `json:"port"`
 }
 
 type Monitor struct {
 	ID              uint       `gorm:"primaryKey" json:"id"`
 	Name            string     `json:"name"`
-	AccountName     string     `json:"account_name"`      // Refers to AppConfig.Accounts
+	AccountName     string     `json:"account_name"`      // Refers to Config.Accounts
 	Target          string     `json:"target"`            // IP or Domain to check
-	Type            string     `json:"type"`              // ping, http
-	DNSType         string     `json:"dns_type"`          // A, AAAA, CNAME
+	Type            string     `json:"type"`              // ping, http, https, tcp, grpc
+	Port            int        `json:"port"`              // tcp/http/grpc check port when Target has none
+	SourceIP        string     `json:"source_ip"`         // Local address checks are sent from (empty = OS default)
+	Resolver        string     `json:"resolver"`          // tcp/http/grpc: DNS server for the target's name (empty = system)
+	GRPCService     string     `json:"grpc_service"`      // gRPC: service name for Health/Check (empty = server)
+	GRPCTLS         bool       `json:"grpc_tls"`          // gRPC: use TLS instead of plaintext
+	InsecureTLS     bool       `json:"insecure_tls"`      // https/grpc: skip certificate verification (self-signed backends)
+	ClientCertPath  string     `json:"client_cert_path"`  // https/grpc: PEM client certificate for mTLS
+	ClientKeyPath   string     `json:"client_key_path"`   // https/grpc: PEM private key for ClientCertPath
+	UserAgent       string     `json:"user_agent"`        // http/https: User-Agent header (empty = checks.user_agent)
+	DNSType         string     `json:"dns_type"`          // A, AAAA, CNAME, MX, SRV
+	RecordPriority  int        `json:"record_priority"`   // MX, SRV
+	RecordWeight    int        `json:"record_weight"`     // SRV
+	RecordPort      int        `json:"record_port"`       // SRV
 	Interval        int        `json:"interval"`          // Seconds
 	Timeout         int        `json:"timeout"`           // Seconds
 	Retries         int        `json:"retries"`           // Failure threshold
 	RecoveryRetries int        `json:"success_threshold"` // Recovery threshold
-	Status          string     `json:"status"`            // Normal, Down
+	Status          string     `json:"status"`            // Normal, Down, Error
+	Paused          bool       `json:"paused"`            // Skip scheduling while paused
+	Public          bool       `json:"public"`            // Listed on the public status page
+	MaxPacketLoss   float64    `json:"max_packet_loss"`   // Ping: loss % above which a check counts as failed (0 = off)
+	MaxLatencyMs    int        `json:"max_latency_ms"`    // HTTP/TCP/gRPC: latency above which a check counts as failed (0 = off)
+	PingAttempts    int        `json:"ping_attempts"`     // Ping: attempts per check, stops at first reply
+	PingPackets     int        `json:"ping_packets"`      // Ping: packets per attempt
+	PingDelay       int        `json:"ping_delay"`        // Ping: milliseconds between attempts
 	LastCheck       time.Time  `json:"last_check"`
 	FailCount       int        `json:"fail_count"`
 	SuccCount       int        `json:"succ_count"`
+	PacketLoss      float64    `json:"packet_loss"` // Last ping check, percent
+	RTTAvg          float64    `json:"rtt_avg"`     // Last ping check, ms
+	RTTMax          float64    `json:"rtt_max"`     // Last ping check, ms
 	CurrentIP       string     `json:"current_ip"`
+	DNSError        string     `json:"dns_error"`     // Last Cloudflare update failure, cleared on success
+	LastError       string     `json:"last_error"`    // Most recent check, DNS or config failure
+	LastErrorAt     time.Time  `json:"last_error_at"` // When LastError happened
 	BackupIP        string     `json:"backup_ip"`
 	OriginalIP      string     `json:"original_ip"`
 	CFZoneID        string     `json:"cf_zone_id"`
 	CFRecordID      string     `json:"cf_record_id"`
+	RecordIDStatus  string     `json:"record_id_status"` // Lookup of a missing CFRecordID: pending, ok, error
 	CFDomain        string     `json:"cf_domain"`
+	CFApiToken      string     `json:"-"` // Optional per-monitor token, overrides account
+	WebhookSecret   string     `json:"-"` // Enables POST /api/monitors/:id/trigger
 	Schedules       []Schedule `gorm:"foreignKey:MonitorID" json:"schedules"`
+
+	// Circuit breaker: consecutive failed automatic DNS switches, and until
+	// when switching is suspended after crossing the threshold
+	DNSFailCount     int       `json:"dns_fail_count"`
+	CircuitOpenUntil time.Time `json:"circuit_open_until"`
+
+	// Extra records switched together with CFRecordID
+	Records []MonitorRecord `gorm:"foreignKey:MonitorID" json:"records"`
+
+	// Composite health: extra checks and how they combine with the main one,
+	// all (every check must pass, default) or any (one passing is enough)
+	Checks    []MonitorCheck `gorm:"foreignKey:MonitorID" json:"checks"`
+	CheckMode string         `json:"check_mode"`
+
+	// Also check BackupIP while the primary is failing, and hold the current
+	// record instead of failing over when the backup is down too
+	CheckBackup  bool   `json:"check_backup"`
+	BackupStatus string `json:"backup_status"` // Up, Down, or empty when not checked yet
+
+	// Recovery dampening: no automatic recovery until FailoverCooldown
+	// seconds after the last failover (0 = off)
+	FailoverCooldown int       `json:"failover_cooldown"`
+	FailoverAt       time.Time `json:"failover_at"`
+
+	// Extra failover targets ranked with BackupIP, and whether to move back
+	// up to a preferred backup once it is healthy again while on a lower one
+	Backups        []MonitorBackup `gorm:"foreignKey:MonitorID" json:"backups"`
+	PromoteBackups bool            `json:"promote_backups"`
+
+	// http/https: what a 2xx/3xx response must also look like, e.g. a
+	// static asset that must not come back empty or as an error page
+	ExpectedContentType string `json:"expected_content_type"` // Media type, or "type/*" (empty = any)
+	MinResponseBytes    int64  `json:"min_response_bytes"`    // 0 = any size
+
+	// How a switch is applied: dns rewrites the record, lb_pool enables the
+	// Cloudflare Load Balancing pool origin whose address is the new IP and
+	// disables the origins of the monitor's other IPs
+	UpdateMode  string `json:"update_mode"`   // dns (default), lb_pool
+	LBAccountID string `json:"lb_account_id"` // Cloudflare account ID owning the pool
+	LBPoolID    string `json:"lb_pool_id"`
+
+	// Active window: checks only run on WindowDays between WindowStart and
+	// WindowEnd; outside it the monitor is skipped and reported Inactive
+	WindowDays     string `json:"window_days"`     // e.g. "mon-fri", "sat,sun"; empty = every day
+	WindowStart    string `json:"window_start"`    // "09:00"; empty with WindowEnd = whole days
+	WindowEnd      string `json:"window_end"`      // "18:00", may be past midnight
+	WindowTimezone string `json:"window_timezone"` // IANA name, empty = server local time
+
+	// Time-based failover: fail over once the primary has been failing for
+	// FailoverAfter seconds, however many checks that spans, instead of after
+	// Retries failed checks in a row (0 = count-based)
+	FailoverAfter int       `json:"failover_after"`
+	FirstFailAt   time.Time `json:"first_fail_at"` // Start of the current failure streak
+
+	// Which notifications this monitor sends, all on by default. Circuit
+	// breaker alerts are always sent.
+	NotifyOnFailover  bool `json:"notify_on_failover"`
+	NotifyOnRecovery  bool `json:"notify_on_recovery"`
+	NotifyOnScheduled bool `json:"notify_on_scheduled"`
+
+	// Soft delete: deleted monitors stay restorable until purged
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+
+	// Not persisted: set on API responses while the whole scheduler is paused
+	SchedulerPaused bool `gorm:"-" json:"scheduler_paused"`
+}
+
+// monitorStateFields are maintained by checks, switches and manual actions
+// under lockMonitor. Config saves leave them out so an edit can't roll back a
+// failover that happened while it was in flight.
+var monitorStateFields = []string{
+	"Status", "Paused", "LastCheck", "FailCount", "SuccCount", "FirstFailAt",
+	"PacketLoss", "RTTAvg", "RTTMax", "CurrentIP", "DNSError", "LastError",
+	"LastErrorAt", "DNSFailCount", "CircuitOpenUntil", "BackupStatus",
+	"FailoverAt", "DeletedAt",
 }
 
 type MonitorConfig struct {
@@ -43,8 +186,23 @@ type MonitorConfig struct {
 	Domain          string           `yaml:"domain" json:"cf_domain"`
 	ZoneID          string           `yaml:"zone_id" json:"cf_zone_id"`
 	RecordID        string           `yaml:"cf_record_id" json:"cf_record_id"`
+	ApiToken        string           `yaml:"api_token" json:"cf_api_token"`
+	WebhookSecret   string           `yaml:"webhook_secret" json:"webhook_secret"`
+	Public          bool             `yaml:"public" json:"public"`
 	Type            string           `yaml:"type" json:"type"`
+	Port            int              `yaml:"port" json:"port"`
+	SourceIP        string           `yaml:"source_ip" json:"source_ip"`
+	Resolver        string           `yaml:"resolver" json:"resolver"`
+	GRPCService     string           `yaml:"grpc_service" json:"grpc_service"`
+	GRPCTLS         bool             `yaml:"grpc_tls" json:"grpc_tls"`
+	InsecureTLS     bool             `yaml:"insecure_tls" json:"insecure_tls"`
+	ClientCertPath  string           `yaml:"client_cert_path" json:"client_cert_path"`
+	ClientKeyPath   string           `yaml:"client_key_path" json:"client_key_path"`
+	UserAgent       string           `yaml:"user_agent" json:"user_agent"`
 	DNSType         string           `yaml:"dns_type" json:"dns_type"`
+	RecordPriority  int              `yaml:"record_priority" json:"record_priority"`
+	RecordWeight    int              `yaml:"record_weight" json:"record_weight"`
+	RecordPort      int              `yaml:"record_port" json:"record_port"`
 	Target          string           `yaml:"target" json:"target"`
 	OriginalIP      string           `yaml:"original_ip" json:"original_ip"`
 	BackupIP        string           `yaml:"backup_ip" json:"backup_ip"`
@@ -52,7 +210,46 @@ type MonitorConfig struct {
 	Timeout         int              `yaml:"timeout" json:"timeout"`
 	Retries         int              `yaml:"retries" json:"retries"`
 	RecoveryRetries int              `yaml:"recovery_retries" json:"success_threshold"`
+	MaxPacketLoss   float64          `yaml:"max_packet_loss" json:"max_packet_loss"`
+	MaxLatencyMs    int              `yaml:"max_latency_ms" json:"max_latency_ms"`
+	PingAttempts    int              `yaml:"ping_attempts" json:"ping_attempts"`
+	PingPackets     int              `yaml:"ping_packets" json:"ping_packets"`
+	PingDelay       int              `yaml:"ping_delay" json:"ping_delay"`
 	Schedules       []ScheduleConfig `yaml:"schedules" json:"schedules"`
+	Records         []RecordConfig   `yaml:"records" json:"records"`
+	Checks          []CheckConfig    `yaml:"checks" json:"checks"`
+	CheckMode       string           `yaml:"check_mode" json:"check_mode"`
+	CheckBackup     bool             `yaml:"check_backup" json:"check_backup"`
+
+	// Seconds after a failover before recovery is allowed, 0 = off
+	FailoverCooldown int `yaml:"failover_cooldown" json:"failover_cooldown"`
+
+	// Extra failover targets, and moving back up to a preferred one
+	Backups        []BackupConfig `yaml:"backups" json:"backups"`
+	PromoteBackups bool           `yaml:"promote_backups" json:"promote_backups"`
+
+	// http/https response checks
+	ExpectedContentType string `yaml:"expected_content_type" json:"expected_content_type"`
+	MinResponseBytes    int64  `yaml:"min_response_bytes" json:"min_response_bytes"`
+
+	// dns (default) or lb_pool, and the pool switched in lb_pool mode
+	UpdateMode  string `yaml:"update_mode" json:"update_mode"`
+	LBAccountID string `yaml:"lb_account_id" json:"lb_account_id"`
+	LBPoolID    string `yaml:"lb_pool_id" json:"lb_pool_id"`
+
+	// When checks run, e.g. mon-fri 09:00-18:00; unset = always
+	WindowDays     string `yaml:"window_days" json:"window_days"`
+	WindowStart    string `yaml:"window_start" json:"window_start"`
+	WindowEnd      string `yaml:"window_end" json:"window_end"`
+	WindowTimezone string `yaml:"window_timezone" json:"window_timezone"`
+
+	// Seconds of failing checks before failover, replacing retries; 0 = off
+	FailoverAfter int `yaml:"failover_after" json:"failover_after"`
+
+	// Unset means enabled
+	NotifyOnFailover  *bool `yaml:"notify_on_failover" json:"notify_on_failover"`
+	NotifyOnRecovery  *bool `yaml:"notify_on_recovery" json:"notify_on_recovery"`
+	NotifyOnScheduled *bool `yaml:"notify_on_scheduled" json:"notify_on_scheduled"`
 }
 
 func (m *Monitor) ApplyDefaults() {
@@ -62,18 +259,507 @@ func (m *Monitor) ApplyDefaults() {
 	if m.Timeout <= 0 {
 		m.Timeout = 5
 	}
+	if m.Type == "" {
+		m.Type = "ping"
+	}
+	// Per check type defaults from checks.defaults, then the built-in ones
+	typeDefaults := GetConfig().Checks.Defaults[m.Type]
+	if m.Retries <= 0 {
+		m.Retries = typeDefaults.Retries
+	}
 	if m.Retries <= 0 {
 		m.Retries = 3
 	}
+	if m.RecoveryRetries <= 0 {
+		m.RecoveryRetries = typeDefaults.RecoveryRetries
+	}
 	if m.RecoveryRetries <= 0 {
 		m.RecoveryRetries = 2
 	}
-	if m.Type == "" {
-		m.Type = "ping"
+	if m.UpdateMode == "" {
+		m.UpdateMode = UpdateModeDNS
 	}
 	if m.DNSType == "" {
 		m.DNSType = "A"
 	}
+	if m.CheckMode == "" {
+		m.CheckMode = "all"
+	}
+	if m.PingAttempts <= 0 {
+		m.PingAttempts = 3
+	}
+	if m.PingPackets <= 0 {
+		m.PingPackets = 1
+	}
+	if m.PingDelay <= 0 {
+		m.PingDelay = 500
+	}
+}
+
+// TLSOptions returns the TLS settings for https/grpc checks. Invalid
+// certificates are accepted if the monitor or checks.insecure_tls allows it.
+func (m *Monitor) TLSOptions() TLSOptions {
+	return TLSOptions{
+		Insecure:   m.InsecureTLS || GetConfig().Checks.InsecureTLS,
+		ClientCert: m.ClientCertPath,
+		ClientKey:  m.ClientKeyPath,
+	}
+}
+
+func (m *Monitor) HTTPOptions() HTTPOptions {
+	checks := GetConfig().Checks
+	ua := m.UserAgent
+	if ua == "" {
+		ua = checks.UserAgent
+	}
+	if ua == "" {
+		ua = defaultUserAgent
+	}
+	return HTTPOptions{
+		UserAgent:   ua,
+		RequestID:   checks.RequestID,
+		ContentType: m.ExpectedContentType,
+		MinBytes:    m.MinResponseBytes,
+	}
+}
+
+func (m *Monitor) DialOptions() DialOptions {
+	return DialOptions{SourceIP: m.SourceIP, Resolver: m.Resolver}
+}
+
+func (m *Monitor) PingOptions() PingOptions {
+	return PingOptions{
+		Attempts: m.PingAttempts,
+		Packets:  m.PingPackets,
+		Delay:    time.Duration(m.PingDelay) * time.Millisecond,
+		Source:   m.SourceIP,
+	}
+}
+
+// Monitor.UpdateMode values
+const (
+	UpdateModeDNS    = "dns"
+	UpdateModeLBPool = "lb_pool"
+)
+
+// ValidateRecordFields checks the update mode and the extra fields required
+// by MX and SRV records.
+func (m *Monitor) ValidateRecordFields() error {
+	switch m.UpdateMode {
+	case "", UpdateModeDNS:
+	case UpdateModeLBPool:
+		if m.LBAccountID == "" || m.LBPoolID == "" {
+			return fmt.Errorf("update_mode lb_pool requires lb_account_id and lb_pool_id")
+		}
+		// Both go into API paths
+		if !validCloudflareID(m.LBAccountID) {
+			return fmt.Errorf("lb_account_id %q is not a 32-character hex Cloudflare ID", m.LBAccountID)
+		}
+		if !validCloudflareID(m.LBPoolID) {
+			return fmt.Errorf("lb_pool_id %q is not a 32-character hex Cloudflare ID", m.LBPoolID)
+		}
+	default:
+		return fmt.Errorf("unsupported update_mode: %s", m.UpdateMode)
+	}
+
+	switch m.DNSType {
+	case "A", "AAAA":
+		if m.UpdateMode == UpdateModeLBPool {
+			return nil // Pool origins may be host names
+		}
+		return m.validateIPFamily()
+	case "CNAME":
+		return nil
+	case "MX":
+		if m.RecordPriority < 0 || m.RecordPriority > 65535 {
+			return fmt.Errorf("record_priority must be between 0 and 65535")
+		}
+	case "SRV":
+		if m.RecordPriority < 0 || m.RecordPriority > 65535 {
+			return fmt.Errorf("record_priority must be between 0 and 65535")
+		}
+		if m.RecordWeight < 0 || m.RecordWeight > 65535 {
+			return fmt.Errorf("record_weight must be between 0 and 65535")
+		}
+		if m.RecordPort < 1 || m.RecordPort > 65535 {
+			return fmt.Errorf("record_port must be between 1 and 65535")
+		}
+	default:
+		return fmt.Errorf("unsupported dns_type: %s", m.DNSType)
+	}
+	return nil
+}
+
+// validateIPFamily checks that the IPs an A or AAAA record is switched
+// between are IPv4 or IPv6 addresses respectively.
+func (m *Monitor) validateIPFamily() error {
+	fields := []string{"original_ip", "backup_ip"}
+	ips := []string{m.OriginalIP, m.BackupIP}
+	for i, b := range m.Backups {
+		fields = append(fields, fmt.Sprintf("backups[%d].ip", i))
+		ips = append(ips, b.IP)
+	}
+	for i, ip := range ips {
+		field := fields[i]
+		if ip == "" {
+			continue
+		}
+		addr := net.ParseIP(ip)
+		if addr == nil {
+			return fmt.Errorf("%s %q is not an IP address", field, ip)
+		}
+		if isIPv4 := addr.To4() != nil; isIPv4 != (m.DNSType == "A") {
+			return fmt.Errorf("%s %s doesn't match dns_type %s", field, ip, m.DNSType)
+		}
+	}
+	return nil
+}
+
+// failoverDue reports whether failCount failed checks in a row, the last one
+// at now, cross the failover threshold: FailoverAfter seconds since the
+// streak began when set, Retries checks otherwise.
+func (m *Monitor) failoverDue(failCount int, now time.Time) bool {
+	if m.FailoverAfter > 0 {
+		// The first failure starts the streak, so it alone is never enough
+		return failCount > 1 && now.Sub(m.FirstFailAt) >= time.Duration(m.FailoverAfter)*time.Second
+	}
+	return m.Retries > 0 && failCount >= m.Retries
+}
+
+// DNSUpdateFailing reports whether the check counter already crossed its
+// threshold without the status flipping, i.e. the DNS switch keeps failing.
+func (m *Monitor) DNSUpdateFailing() bool {
+	switch m.Status {
+	case "Normal":
+		return m.FailCount > 0 && m.failoverDue(m.FailCount, time.Now())
+	case "Down":
+		return m.RecoveryRetries > 0 && m.SuccCount >= m.RecoveryRetries
+	}
+	return false
+}
+
+// ServingStatus is Normal or Down. While the monitor is in Error it is
+// derived from the IP the record currently points at, so callers must load
+// Backups first.
+func (m *Monitor) ServingStatus() string {
+	if m.Status != "Error" {
+		return m.Status
+	}
+	if m.IsBackupIP(m.CurrentIP) {
+		return "Down"
+	}
+	return "Normal"
+}
+
+// IsBackupIP reports whether ip is BackupIP or one of the extra backups
+// (which must be loaded).
+func (m *Monitor) IsBackupIP(ip string) bool {
+	return ip != "" && slices.Contains(m.BackupIPs(), ip)
+}
+
+// BackupIPs lists the failover targets in order of preference: lowest
+// priority first, BackupIP (priority 0) ahead of extra backups it ties with.
+func (m *Monitor) BackupIPs() []string {
+	if m.BackupIP == "" {
+		return nil
+	}
+	backups := append([]MonitorBackup{{IP: m.BackupIP}}, m.Backups...)
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].Priority < backups[j].Priority })
+	ips := make([]string, len(backups))
+	for i, b := range backups {
+		ips[i] = b.IP
+	}
+	return ips
+}
+
+// activeWindow is the parsed form of the monitor's window fields.
+type activeWindow struct {
+	days       [7]bool // Indexed by time.Weekday
+	start, end int     // Minutes since midnight; equal = whole day
+	loc        *time.Location
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseWeekdays parses a list like "mon-fri" or "sat,sun". Ranges may wrap
+// around the week, e.g. "fri-mon".
+func parseWeekdays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, ok := weekdayNames[strings.TrimSpace(from)]
+		if !ok {
+			return days, fmt.Errorf("invalid window_days %q: unknown day %q", s, from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[strings.TrimSpace(to)]; !ok {
+				return days, fmt.Errorf("invalid window_days %q: unknown day %q", s, to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// activeWindow parses the window fields, nil when the monitor has none.
+func (m *Monitor) activeWindow() (*activeWindow, error) {
+	if m.WindowDays == "" && m.WindowStart == "" && m.WindowEnd == "" {
+		return nil, nil
+	}
+	w := &activeWindow{days: [7]bool{true, true, true, true, true, true, true}, loc: time.Local}
+	var err error
+	if m.WindowDays != "" {
+		if w.days, err = parseWeekdays(m.WindowDays); err != nil {
+			return nil, err
+		}
+	}
+	if (m.WindowStart == "") != (m.WindowEnd == "") {
+		return nil, fmt.Errorf("window_start and window_end must be set together")
+	}
+	if m.WindowStart != "" {
+		if w.start, err = parseClock(m.WindowStart); err != nil {
+			return nil, fmt.Errorf("window_start: %w", err)
+		}
+		if w.end, err = parseClock(m.WindowEnd); err != nil {
+			return nil, fmt.Errorf("window_end: %w", err)
+		}
+		if w.start == w.end {
+			return nil, fmt.Errorf("window_start and window_end must differ")
+		}
+	}
+	if m.WindowTimezone != "" {
+		if w.loc, err = time.LoadLocation(m.WindowTimezone); err != nil {
+			return nil, fmt.Errorf("window_timezone: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// InWindow reports whether t falls in the monitor's active window; always
+// true without one. A window past midnight belongs to the day it starts on,
+// so "fri 22:00-06:00" covers early Saturday but not early Friday.
+func (m *Monitor) InWindow(t time.Time) bool {
+	w, err := m.activeWindow()
+	if err != nil || w == nil {
+		return true // Invalid windows are rejected on save, never skip checks over one
+	}
+	t = t.In(w.loc)
+	day := t.Weekday()
+	if w.start == w.end {
+		return w.days[day]
+	}
+	now := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.days[day] && now >= w.start && now < w.end
+	}
+	if now >= w.start {
+		return w.days[day]
+	}
+	return now < w.end && w.days[(day+6)%7]
+}
+
+// CircuitOpen reports whether automatic DNS switching is suspended.
+func (m *Monitor) CircuitOpen() bool {
+	return time.Now().Before(m.CircuitOpenUntil)
+}
+
+// Notifies reports whether a notification of the given kind is enabled for
+// this monitor. Manual switches follow the automatic failover/recovery flags.
+func (m *Monitor) Notifies(kind string) bool {
+	switch kind {
+	case NotifyFailover, NotifyManualFailover:
+		return m.NotifyOnFailover
+	case NotifyRecovery, NotifyManualRestore:
+		return m.NotifyOnRecovery
+	case NotifyScheduledSwitch:
+		return m.NotifyOnScheduled
+	}
+	return true
+}
+
+// ConfigChanges lists the config fields that differ between two versions of
+// a monitor as "field: old -> new". Secrets are only reported as changed.
+func ConfigChanges(before, after Monitor) []string {
+	var changes []string
+	oldFields, newFields := configFields(before), configFields(after)
+	keys := make([]string, 0, len(newFields))
+	for k := range newFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if oldFields[k] != newFields[k] {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, oldFields[k], newFields[k]))
+		}
+	}
+	if before.CFApiToken != after.CFApiToken {
+		changes = append(changes, "cf_api_token: changed")
+	}
+	if before.WebhookSecret != after.WebhookSecret {
+		changes = append(changes, "webhook_secret: changed")
+	}
+	return changes
+}
+
+// configFields flattens ToConfig into printable values keyed by yaml name.
+func configFields(m Monitor) map[string]string {
+	fields := map[string]string{}
+	v := reflect.ValueOf(m.ToConfig())
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Slice:
+			continue // Schedules, records and checks aren't part of ToConfig
+		case f.Kind() == reflect.Pointer:
+			if f.IsNil() {
+				fields[name] = ""
+				continue
+			}
+			f = f.Elem()
+		}
+		fields[name] = fmt.Sprint(f.Interface())
+	}
+	return fields
+}
+
+func boolOr(p *bool, def bool) bool {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// ValidateCheckFields checks the health check settings.
+func (m *Monitor) ValidateCheckFields() error {
+	if m.MaxPacketLoss < 0 || m.MaxPacketLoss > 100 {
+		return fmt.Errorf("max_packet_loss must be between 0 and 100")
+	}
+	if m.MaxLatencyMs < 0 {
+		return fmt.Errorf("max_latency_ms must not be negative")
+	}
+	if m.FailoverCooldown < 0 {
+		return fmt.Errorf("failover_cooldown must not be negative")
+	}
+	if m.FailoverAfter < 0 {
+		return fmt.Errorf("failover_after must not be negative")
+	}
+	if m.MinResponseBytes < 0 {
+		return fmt.Errorf("min_response_bytes must not be negative")
+	}
+	if _, err := m.activeWindow(); err != nil {
+		return err
+	}
+	if m.ExpectedContentType != "" {
+		if _, _, err := mime.ParseMediaType(m.ExpectedContentType); err != nil {
+			return fmt.Errorf("invalid expected_content_type: %v", err)
+		}
+	}
+	if m.SourceIP != "" {
+		if err := validateSourceIP(m.SourceIP); err != nil {
+			return err
+		}
+	}
+	if m.Resolver != "" {
+		if err := validateResolver(m.Resolver); err != nil {
+			return err
+		}
+	}
+	if m.Port < 0 || m.Port > 65535 {
+		return fmt.Errorf("port must be between 0 (default) and 65535")
+	}
+	if strings.ContainsAny(m.UserAgent, "\r\n") {
+		return fmt.Errorf("user_agent must be a single line")
+	}
+	if (m.ClientCertPath == "") != (m.ClientKeyPath == "") {
+		return fmt.Errorf("client_cert_path and client_key_path must be set together")
+	}
+	if m.Type == "tcp" && m.Port == 0 {
+		if _, _, err := net.SplitHostPort(m.Target); err != nil {
+			return fmt.Errorf("tcp checks require a port")
+		}
+	}
+	if m.CheckMode != "" && m.CheckMode != "all" && m.CheckMode != "any" {
+		return fmt.Errorf("check_mode must be all or any")
+	}
+	return ValidateChecks(m.Checks, m.Type)
+}
+
+// ValidateBackups checks the extra backups, which rank alongside backupIP.
+func ValidateBackups(backups []MonitorBackup, backupIP, originalIP string) error {
+	if len(backups) > 0 && backupIP == "" {
+		return fmt.Errorf("backup_ip is required with backups")
+	}
+	seen := map[string]bool{backupIP: true}
+	for _, b := range backups {
+		if b.IP == "" {
+			return fmt.Errorf("backup ip is required")
+		}
+		if b.IP == originalIP || seen[b.IP] {
+			return fmt.Errorf("backup %s is listed twice or is the original_ip", b.IP)
+		}
+		seen[b.IP] = true
+	}
+	return nil
+}
+
+// ValidateChecks checks the extra health checks of a monitor of the given type.
+func ValidateChecks(checks []MonitorCheck, monitorType string) error {
+	for _, c := range checks {
+		if c.Target == "" {
+			return fmt.Errorf("check target is required")
+		}
+		switch c.Type {
+		case "", "ping", "http", "https", "tcp", "grpc":
+		default:
+			return fmt.Errorf("check %s: unknown type %q", c.Target, c.Type)
+		}
+		if c.Port < 0 || c.Port > 65535 {
+			return fmt.Errorf("check %s: port must be between 0 (default) and 65535", c.Target)
+		}
+		checkType := c.Type
+		if checkType == "" {
+			checkType = monitorType
+		}
+		if checkType == "tcp" && c.Port == 0 {
+			if _, _, err := net.SplitHostPort(c.Target); err != nil {
+				return fmt.Errorf("check %s: tcp checks require a port", c.Target)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateAccount checks that a named account exists. With a monitor-scoped
+// token the account name is only a label.
+func (m *Monitor) ValidateAccount() error {
+	if m.AccountName == "" || m.CFApiToken != "" || FindAccount(m.AccountName) != nil {
+		return nil
+	}
+	return accountError(m.AccountName)
+}
+
+// Validate runs all config checks.
+func (m *Monitor) Validate() error {
+	if err := m.ValidateAccount(); err != nil {
+		return err
+	}
+	if err := m.ValidateRecordFields(); err != nil {
+		return err
+	}
+	if err := ValidateBackups(m.Backups, m.BackupIP, m.OriginalIP); err != nil {
+		return err
+	}
+	return m.ValidateCheckFields()
 }
 
 func (mc *MonitorConfig) ToMonitor() Monitor {
@@ -82,16 +768,67 @@ func (mc *MonitorConfig) ToMonitor() Monitor {
 		AccountName:     mc.Account,
 		Target:          mc.Target,
 		Type:            mc.Type,
+		Port:            mc.Port,
+		SourceIP:        mc.SourceIP,
+		Resolver:        mc.Resolver,
+		GRPCService:     mc.GRPCService,
+		GRPCTLS:         mc.GRPCTLS,
+		InsecureTLS:     mc.InsecureTLS,
+		ClientCertPath:  mc.ClientCertPath,
+		ClientKeyPath:   mc.ClientKeyPath,
+		UserAgent:       mc.UserAgent,
 		DNSType:         mc.DNSType,
+		RecordPriority:  mc.RecordPriority,
+		RecordWeight:    mc.RecordWeight,
+		RecordPort:      mc.RecordPort,
 		Interval:        mc.Interval,
 		Timeout:         mc.Timeout,
 		Retries:         mc.Retries,
 		RecoveryRetries: mc.RecoveryRetries,
+		MaxPacketLoss:   mc.MaxPacketLoss,
+		MaxLatencyMs:    mc.MaxLatencyMs,
+		PingAttempts:    mc.PingAttempts,
+		PingPackets:     mc.PingPackets,
+		PingDelay:       mc.PingDelay,
 		OriginalIP:      mc.OriginalIP,
 		BackupIP:        mc.BackupIP,
 		CFZoneID:        mc.ZoneID,
 		CFRecordID:      mc.RecordID,
 		CFDomain:        mc.Domain,
+		CFApiToken:      mc.ApiToken,
+		WebhookSecret:   mc.WebhookSecret,
+		Public:          mc.Public,
+		CheckBackup:     mc.CheckBackup,
+
+		FailoverCooldown:  mc.FailoverCooldown,
+		NotifyOnFailover:  boolOr(mc.NotifyOnFailover, true),
+		NotifyOnRecovery:  boolOr(mc.NotifyOnRecovery, true),
+		NotifyOnScheduled: boolOr(mc.NotifyOnScheduled, true),
+
+		ExpectedContentType: mc.ExpectedContentType,
+		MinResponseBytes:    mc.MinResponseBytes,
+
+		UpdateMode:  mc.UpdateMode,
+		LBAccountID: mc.LBAccountID,
+		LBPoolID:    mc.LBPoolID,
+
+		WindowDays:     mc.WindowDays,
+		WindowStart:    mc.WindowStart,
+		WindowEnd:      mc.WindowEnd,
+		WindowTimezone: mc.WindowTimezone,
+
+		FailoverAfter: mc.FailoverAfter,
+	}
+	for _, rc := range mc.Records {
+		m.Records = append(m.Records, rc.ToRecord())
+	}
+	for _, bc := range mc.Backups {
+		m.Backups = append(m.Backups, bc.ToBackup())
+	}
+	m.PromoteBackups = mc.PromoteBackups
+	m.CheckMode = mc.CheckMode
+	for _, cc := range mc.Checks {
+		m.Checks = append(m.Checks, cc.ToCheck())
 	}
 
 	m.ApplyDefaults()
@@ -99,9 +836,143 @@ func (mc *MonitorConfig) ToMonitor() Monitor {
 	return m
 }
 
+// ToConfig is the inverse of ToMonitor for the plain config fields. Secrets,
+// the record ID and the schedule/record/check lists are left empty: updates
+// treat those as "keep" or "look up again" when not sent.
+func (m *Monitor) ToConfig() MonitorConfig {
+	return MonitorConfig{
+		Name:              m.Name,
+		Account:           m.AccountName,
+		Domain:            m.CFDomain,
+		ZoneID:            m.CFZoneID,
+		Public:            m.Public,
+		Type:              m.Type,
+		Port:              m.Port,
+		SourceIP:          m.SourceIP,
+		Resolver:          m.Resolver,
+		GRPCService:       m.GRPCService,
+		GRPCTLS:           m.GRPCTLS,
+		InsecureTLS:       m.InsecureTLS,
+		ClientCertPath:    m.ClientCertPath,
+		ClientKeyPath:     m.ClientKeyPath,
+		UserAgent:         m.UserAgent,
+		DNSType:           m.DNSType,
+		RecordPriority:    m.RecordPriority,
+		RecordWeight:      m.RecordWeight,
+		RecordPort:        m.RecordPort,
+		Target:            m.Target,
+		OriginalIP:        m.OriginalIP,
+		BackupIP:          m.BackupIP,
+		Interval:          m.Interval,
+		Timeout:           m.Timeout,
+		Retries:           m.Retries,
+		RecoveryRetries:   m.RecoveryRetries,
+		MaxPacketLoss:     m.MaxPacketLoss,
+		MaxLatencyMs:      m.MaxLatencyMs,
+		PingAttempts:      m.PingAttempts,
+		PingPackets:       m.PingPackets,
+		PingDelay:         m.PingDelay,
+		CheckMode:         m.CheckMode,
+		CheckBackup:       m.CheckBackup,
+		FailoverCooldown:  m.FailoverCooldown,
+		NotifyOnFailover:  &m.NotifyOnFailover,
+		NotifyOnRecovery:  &m.NotifyOnRecovery,
+		NotifyOnScheduled: &m.NotifyOnScheduled,
+
+		ExpectedContentType: m.ExpectedContentType,
+		MinResponseBytes:    m.MinResponseBytes,
+		PromoteBackups:      m.PromoteBackups,
+
+		UpdateMode:  m.UpdateMode,
+		LBAccountID: m.LBAccountID,
+		LBPoolID:    m.LBPoolID,
+
+		WindowDays:     m.WindowDays,
+		WindowStart:    m.WindowStart,
+		WindowEnd:      m.WindowEnd,
+		WindowTimezone: m.WindowTimezone,
+
+		FailoverAfter: m.FailoverAfter,
+	}
+}
+
 type ScheduleConfig struct {
-	Cron     string `yaml:"cron" json:"cron"`
-	TargetIP string `yaml:"target_ip" json:"target_ip"`
+	Cron        string `yaml:"cron" json:"cron"`
+	TargetIP    string `yaml:"target_ip" json:"target_ip"`
+	RevertAfter string `yaml:"revert_after" json:"revert_after"`
+}
+
+// parseRevertAfter parses a schedule's revert_after, 0 when unset.
+func parseRevertAfter(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("revert_after must be a positive duration such as \"2h\"")
+	}
+	return d, nil
+}
+
+type RecordConfig struct {
+	Domain   string `yaml:"domain" json:"cf_domain"`
+	ZoneID   string `yaml:"zone_id" json:"cf_zone_id"`
+	RecordID string `yaml:"cf_record_id" json:"cf_record_id"`
+}
+
+func (rc RecordConfig) ToRecord() MonitorRecord {
+	return MonitorRecord{ZoneID: rc.ZoneID, RecordID: rc.RecordID, Domain: rc.Domain}
+}
+
+type BackupConfig struct {
+	IP       string `yaml:"ip" json:"ip"`
+	Priority int    `yaml:"priority" json:"priority"`
+}
+
+func (bc BackupConfig) ToBackup() MonitorBackup {
+	return MonitorBackup{IP: bc.IP, Priority: bc.Priority}
+}
+
+type CheckConfig struct {
+	Type   string `yaml:"type" json:"type"`
+	Target string `yaml:"target" json:"target"`
+	Port   int    `yaml:"port" json:"port"`
+}
+
+func (cc CheckConfig) ToCheck() MonitorCheck {
+	return MonitorCheck{Type: cc.Type, Target: cc.Target, Port: cc.Port}
+}
+
+type Event struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	MonitorID uint      `gorm:"index" json:"monitor_id"`
+	Type      string    `json:"type"` // create, update, delete, restore, failover, recovery, scheduled_switch, pause, resume, scheduler_pause, scheduler_resume, error, circuit_open, error_cleared, setup, drift, undelete, no_healthy, reseed, config_update, check_all
+	Message   string    `json:"message"`
+	OldIP     string    `json:"old_ip"`
+	NewIP     string    `json:"new_ip"`
+	Actor     string    `json:"actor"` // Username, api-key:<name>, client IP, or system
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// CheckResult is one health check outcome, kept as check history.
+type CheckResult struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	MonitorID  uint      `gorm:"index" json:"monitor_id"`
+	Up         bool      `json:"up"`
+	Latency    float64   `json:"latency"`     // ms
+	StatusCode int       `json:"status_code"` // HTTP only
+	PacketLoss float64   `json:"packet_loss"` // Ping only, percent
+	RTTAvg     float64   `json:"rtt_avg"`     // Ping only, ms
+	RTTMax     float64   `json:"rtt_max"`     // Ping only, ms
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+type User struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	Username     string    `gorm:"uniqueIndex" json:"username"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"` // admin, readonly
+	CreatedAt    time.Time `json:"created_at"`
 }
 
 type GlobalConfig struct {
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestValidateIPFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		dnsType  string
+		original string
+		backup   string
+		backups  []MonitorBackup
+		wantErr  bool
+	}{
+		{"A with IPv4", "A", "192.0.2.1", "192.0.2.2", nil, false},
+		{"A rejects IPv6 original", "A", "2001:db8::1", "192.0.2.2", nil, true},
+		{"A rejects IPv6 backup", "A", "192.0.2.1", "2001:db8::2", nil, true},
+		{"A rejects IPv6 extra backup", "A", "192.0.2.1", "192.0.2.2", []MonitorBackup{{IP: "2001:db8::3"}}, true},
+		{"AAAA with IPv6", "AAAA", "2001:db8::1", "2001:db8::2", []MonitorBackup{{IP: "2001:db8::3"}}, false},
+		{"AAAA rejects IPv4 original", "AAAA", "192.0.2.1", "2001:db8::2", nil, true},
+		{"AAAA rejects IPv4 backup", "AAAA", "2001:db8::1", "192.0.2.2", nil, true},
+		{"AAAA rejects IPv4-mapped", "AAAA", "::ffff:192.0.2.1", "", nil, true},
+		{"not an IP", "A", "origin.example.com", "", nil, true},
+		{"empty backup", "A", "192.0.2.1", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Monitor{DNSType: tt.dnsType, OriginalIP: tt.original, BackupIP: tt.backup, Backups: tt.backups}
+			if err := m.validateIPFamily(); (err != nil) != tt.wantErr {
+				t.Errorf("validateIPFamily() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRecordFieldsLBPool(t *testing.T) {
+	const id = "023e105f4ecef8ad9ca31a8372d0c353"
+	tests := []struct {
+		name      string
+		accountID string
+		poolID    string
+		wantErr   bool
+	}{
+		{"valid", id, "17b5962d775c646f3f9725cbc7a53df4", false},
+		{"missing pool", id, "", true},
+		{"account not hex", "my-account", id, true},
+		{"pool with path", id, "../../user/tokens", true},
+		{"uppercase", id, "17B5962D775C646F3F9725CBC7A53DF4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Monitor{DNSType: "A", UpdateMode: UpdateModeLBPool, LBAccountID: tt.accountID, LBPoolID: tt.poolID}
+			if err := m.ValidateRecordFields(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRecordFields() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,20 +2,32 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os/exec"
+	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/robfig/cron/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // --- Engine ---
@@ -27,34 +39,164 @@ var (
 	// HTTP Client Cache to reuse connections (Keep-Alive)
 	httpClientMutex sync.Mutex
 	httpClients     = make(map[string]*http.Client)
+
+	// Per-monitor locks serializing state changes and DNS updates
+	monitorLocks sync.Map // map[uint]*sync.Mutex
 )
 
-func getHTTPClient(forceIP string, timeout int) *http.Client {
+// lockMonitor acquires the per-monitor lock and returns its unlock function.
+// Health checks, scheduled switches and manual actions all take this lock before
+// touching monitor state or Cloudflare, so they can't interleave.
+func lockMonitor(id uint) func() {
+	v, _ := monitorLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// TLSOptions configures certificate handling for https and grpc checks.
+type TLSOptions struct {
+	Insecure   bool   // Skip server certificate verification
+	ClientCert string // PEM file presented for mutual TLS
+	ClientKey  string
+}
+
+// config builds the tls.Config, loading the client certificate if set.
+func (o TLSOptions) config(serverName string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName, InsecureSkipVerify: o.Insecure}
+	if o.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// DialOptions configures how tcp, http and grpc checks connect.
+type DialOptions struct {
+	SourceIP string // Local address to bind, empty = OS default
+	Resolver string // DNS server ("ip" or "ip:port") for the target's name, empty = system resolver
+}
+
+// dialer builds a net.Dialer bound to SourceIP that resolves names through
+// Resolver when set.
+func (o DialOptions) dialer(timeout time.Duration) *net.Dialer {
+	d := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	if o.SourceIP != "" {
+		d.LocalAddr = &net.TCPAddr{IP: net.ParseIP(o.SourceIP)}
+	}
+	if o.Resolver != "" {
+		server := resolverAddr(o.Resolver)
+		d.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{Timeout: timeout}).DialContext(ctx, network, server)
+			},
+		}
+	}
+	return d
+}
+
+// resolverAddr adds the default DNS port to a resolver given as a bare IP.
+func resolverAddr(resolver string) string {
+	if _, _, err := net.SplitHostPort(resolver); err == nil {
+		return resolver
+	}
+	return net.JoinHostPort(resolver, "53")
+}
+
+// unbracketIP strips the brackets of an IPv6 literal written as in a URL
+// ("[2001:db8::1]"), which net.JoinHostPort would otherwise add again.
+func unbracketIP(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// validateConnectIP checks the address a check connects to instead of
+// resolving its target: an IP, or a host name for CNAME records.
+func validateConnectIP(ip string) error {
+	if strings.Contains(ip, ":") && net.ParseIP(ip) == nil {
+		return fmt.Errorf("connect address %q is not a valid IP address", ip)
+	}
+	return nil
+}
+
+// validateResolver checks that resolver is an IP, optionally with a port.
+func validateResolver(resolver string) error {
+	host, _, err := net.SplitHostPort(resolverAddr(resolver))
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("resolver %q must be an IP address, optionally with a port", resolver)
+	}
+	return nil
+}
+
+// sourceError makes a failure to bind to source_ip stand out from ordinary
+// connection errors.
+func sourceError(err error, sourceIP string) error {
+	if sourceIP != "" && errors.Is(err, syscall.EADDRNOTAVAIL) {
+		return fmt.Errorf("cannot bind to source_ip %s: %w", sourceIP, err)
+	}
+	return err
+}
+
+// validateSourceIP checks that ip is assigned to one of this host's
+// interfaces, so checks can be sent from it.
+func validateSourceIP(ip string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return fmt.Errorf("source_ip %q is not an IP address", ip)
+	}
+	ifAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("source_ip: failed to list local addresses: %v", err)
+	}
+	for _, a := range ifAddrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source_ip %s is not an address of this host", ip)
+}
+
+func getHTTPClient(forceIP string, timeout int, dialOpts DialOptions, tlsOpts TLSOptions) (*http.Client, error) {
 	httpClientMutex.Lock()
 	defer httpClientMutex.Unlock()
 
 	// Key based on configuration.
 	// Note: If monitors have same forceIP but different timeouts, they need different clients
-	// because http.Client.Timeout is struct field.
-	key := fmt.Sprintf("%s-%d", forceIP, timeout)
+	// because http.Client.Timeout is struct field. The client certificate is
+	// part of the key so monitors never share an identity.
+	key := fmt.Sprintf("%s-%s-%s-%d-%t-%s-%s", forceIP, dialOpts.SourceIP, dialOpts.Resolver, timeout, tlsOpts.Insecure, tlsOpts.ClientCert, tlsOpts.ClientKey)
 
 	if client, ok := httpClients[key]; ok {
-		return client
+		return client, nil
+	}
+
+	// Not cached on error, so a fixed certificate is picked up on the next check
+	tlsConfig, err := tlsOpts.config("")
+	if err != nil {
+		return nil, err
 	}
 
 	tr := &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true}, // Monitor might check self-signed
-		DisableKeepAlives:   false,                                 // Enable Keep-Alive
-		MaxIdleConnsPerHost: 10,                                    // Allow concurrent checks to same host
+		TLSClientConfig:     tlsConfig,
+		DisableKeepAlives:   false, // Enable Keep-Alive
+		MaxIdleConnsPerHost: 10,    // Allow concurrent checks to same host
 		IdleConnTimeout:     90 * time.Second,
 	}
 
+	// TCP connect timeout; also applies the source address and resolver
+	dialer := dialOpts.dialer(5 * time.Second)
+	if dialOpts != (DialOptions{}) {
+		tr.DialContext = dialer.DialContext
+	}
+
 	// If forceIP is provided, override DNS resolution
 	if forceIP != "" {
-		dialer := &net.Dialer{
-			Timeout:   5 * time.Second, // TCP Connect timeout
-			KeepAlive: 30 * time.Second,
-		}
 		tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 			// addr is "hostname:port".
 			_, port, err := net.SplitHostPort(addr)
@@ -72,7 +214,24 @@ func getHTTPClient(forceIP string, timeout int) *http.Client {
 		Transport: tr,
 	}
 	httpClients[key] = client
-	return client
+	return client, nil
+}
+
+// GlobalConfig key holding the global pause flag ("true" while paused)
+const schedulerPausedKey = "scheduler_paused"
+
+func SchedulerPaused() bool {
+	return GetGlobalConfig(schedulerPausedKey) == "true"
+}
+
+// SetSchedulerPaused persists the global pause flag and reloads the scheduler.
+// Monitor state is left untouched, so resuming continues where it stopped.
+func SetSchedulerPaused(paused bool) error {
+	if err := SetGlobalConfig(schedulerPausedKey, strconv.FormatBool(paused)); err != nil {
+		return err
+	}
+	ReloadSchedules()
+	return nil
 }
 
 func StartScheduler() {
@@ -86,27 +245,60 @@ func StopScheduler() {
 	if Scheduler != nil {
 		ctx := Scheduler.Stop()
 		<-ctx.Done() // Wait for running jobs to complete
-		log.Println("Scheduler stopped and all jobs completed.")
+		slog.Info("Scheduler stopped and all jobs completed")
 	}
 }
 
+// ReloadSchedules replaces the scheduler with one built from the current
+// monitors. Jobs of the old scheduler are waited for first, so a failover or
+// restore in progress finishes its Cloudflare update before anything changes.
+// Scheduled jobs must not call it, or the wait would never end.
 func ReloadSchedules() {
 	schedulerMutex.Lock()
 	defer schedulerMutex.Unlock()
 
 	if Scheduler != nil {
-		Scheduler.Stop()
+		<-Scheduler.Stop().Done() // As in StopScheduler
 	}
 	Scheduler = cron.New(cron.WithChain(
 		cron.SkipIfStillRunning(cron.DefaultLogger),
 	))
 	Scheduler.Start()
 
+	// Maintenance runs even while monitoring is paused
+	if _, err := Scheduler.AddFunc("@daily", PruneHistory); err != nil {
+		slog.Error("Failed to schedule history pruning", "error", err)
+	}
+	if _, err := Scheduler.AddFunc("@daily", PurgeDeletedMonitors); err != nil {
+		slog.Error("Failed to schedule purging deleted monitors", "error", err)
+	}
+	if _, err := Scheduler.AddFunc("@weekly", VacuumDatabase); err != nil {
+		slog.Error("Failed to schedule database vacuum", "error", err)
+	}
+
+	// Global pause: no monitor jobs, so nothing checks or switches
+	if SchedulerPaused() {
+		slog.Warn("Scheduler is globally paused, no monitors scheduled")
+		return
+	}
+
+	if interval := GetConfig().DriftCheck.Interval; interval > 0 {
+		if _, err := Scheduler.AddFunc(fmt.Sprintf("@every %dm", interval), CheckDNSDrift); err != nil {
+			slog.Error("Failed to schedule DNS drift check", "error", err)
+		}
+	}
+
 	var monitors []Monitor
 	DB.Preload("Schedules").Find(&monitors)
 
 	// 1. Monitoring Jobs
+	active := 0
 	for _, m := range monitors {
+		if m.Paused {
+			continue
+		}
+		active++
+
 		mCopy := m
 		mCopy.ApplyDefaults()
 		interval := mCopy.Interval
@@ -114,7 +306,7 @@ func ReloadSchedules() {
 		if _, err := Scheduler.AddFunc(fmt.Sprintf("@every %ds", interval), func() {
 			CheckMonitor(&mCopy)
 		}); err != nil {
-			log.Printf("Failed to schedule monitor %d: %v", mCopy.ID, err)
+			slog.Error("Failed to schedule monitor", "monitor_id", mCopy.ID, "error", err)
 		}
 
 		// 2. Schedule Jobs
@@ -122,169 +314,857 @@ func ReloadSchedules() {
 			monitorID := mCopy.ID
 			targetIP := s.TargetIP
 			cronExpr := s.Cron
+			revertAfter, err := parseRevertAfter(s.RevertAfter)
+			if err != nil {
+				slog.Error("Ignoring schedule revert", "monitor_id", monitorID, "cron", cronExpr, "error", err)
+			}
 			if _, err := Scheduler.AddFunc(cronExpr, func() {
-				ScheduledSwitch(monitorID, targetIP)
+				ScheduledSwitch(monitorID, targetIP, revertAfter)
 			}); err != nil {
-				log.Printf("Failed to schedule switch for monitor %d: %v", monitorID, err)
+				slog.Error("Failed to schedule switch", "monitor_id", monitorID, "cron", cronExpr, "error", err)
 			}
 		}
 	}
 
-	log.Printf("Scheduler reloaded. Monitoring %d targets.", len(monitors))
+	slog.Info("Scheduler reloaded", "monitors", active, "paused", len(monitors)-active)
+}
+
+// Delay between starting startup checks, so a restart doesn't probe every
+// target at the same instant
+const startupCheckStagger = 200 * time.Millisecond
+
+// Startup checks are waited for on shutdown like scheduler jobs; cancelling
+// startupCtx stops new ones from being started
+var (
+	startupChecks                 sync.WaitGroup
+	startupCtx, stopStartupChecks = context.WithCancel(appCtx)
+)
+
+// StartStartupChecks runs RunStartupChecks in the background, tracked by
+// WaitStartupChecks.
+func StartStartupChecks() {
+	startupChecks.Add(1)
+	go func() {
+		defer startupChecks.Done()
+		RunStartupChecks()
+	}()
 }
 
-func ScheduledSwitch(monitorID uint, targetIP string) {
+// StopStartupChecks stops starting startup checks and waits for the ones
+// already running.
+func StopStartupChecks() {
+	stopStartupChecks()
+	startupChecks.Wait()
+}
+
+// RunStartupChecks checks each active monitor once right after boot, so a
+// service that went down meanwhile is noticed without waiting a full
+// interval. Checks start staggered, at most checkAllParallel at a time, and
+// stop being started once the scheduler is paused or shutdown begins.
+func RunStartupChecks() {
+	var monitors []Monitor
+	DB.Where("paused = ?", false).Find(&monitors)
+	// Dropped up front so monitors outside their window don't hold up the
+	// others' stagger
+	now := time.Now()
+	monitors = slices.DeleteFunc(monitors, func(m Monitor) bool { return !m.InWindow(now) })
+
+	sem := make(chan struct{}, checkAllParallel)
+	var wg sync.WaitGroup
+	checked := 0
+	for i := range monitors {
+		if i > 0 {
+			select {
+			case <-startupCtx.Done():
+			case <-time.After(startupCheckStagger):
+			}
+		}
+		if startupCtx.Err() != nil || SchedulerPaused() {
+			break
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		checked++
+		go func(m *Monitor) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			CheckMonitor(m)
+		}(&monitors[i])
+	}
+	wg.Wait()
+	slog.Info("Startup checks done", "checked", checked, "monitors", len(monitors))
+}
+
+// ScheduledSwitch points the record at targetIP. With revertAfter set, a
+// follow-up switch back to the original IP is queued for that much later.
+func ScheduledSwitch(monitorID uint, targetIP string, revertAfter time.Duration) {
+	unlock := lockMonitor(monitorID)
+	defer unlock()
+
 	var m Monitor
 	if err := DB.First(&m, monitorID).Error; err != nil {
-		log.Println("ScheduledSwitch: Monitor not found", monitorID)
+		slog.Warn("ScheduledSwitch: monitor not found", "monitor_id", monitorID)
 		return
 	}
 
 	// Avoid switching if failover is active (Status == Down)
 	if m.Status == "Down" {
-		log.Printf("Skipping scheduled switch for %s because it is Down", m.Name)
+		slog.Info("Skipping scheduled switch because monitor is Down", "monitor_id", m.ID, "monitor", m.Name)
+		return
+	}
+	if m.CircuitOpen() {
+		slog.Info("Skipping scheduled switch because DNS updates are suspended", "monitor_id", m.ID, "monitor", m.Name)
 		return
 	}
 
-	log.Printf("Executing scheduled switch for %s to %s", m.Name, targetIP)
+	slog.Info("Executing scheduled switch", "monitor_id", m.ID, "monitor", m.Name, "event", "scheduled_switch", "old_ip", m.CurrentIP, "new_ip", targetIP)
 
 	// Update DNS
+	oldIP := m.CurrentIP
 	if UpdateCloudflareDNS(&m, targetIP) {
 		m.CurrentIP = targetIP
 		m.FailCount = 0
 		m.SuccCount = 0
 		DB.Model(&m).Select("CurrentIP", "FailCount", "SuccCount").Updates(&m)
-		SendNotification(fmt.Sprintf("🕒 计划任务: %s 已切换至 IP %s", m.Name, targetIP))
+		if m.Notifies(NotifyScheduledSwitch) {
+			SendNotification(NotifyEvent{Kind: NotifyScheduledSwitch, Monitor: m.Name, OldIP: oldIP, NewIP: targetIP})
+		}
+		RecordEvent(Event{MonitorID: m.ID, Type: "scheduled_switch", Message: "Scheduled switch", OldIP: oldIP, NewIP: targetIP, Actor: "system"})
+		if revertAfter > 0 && targetIP != m.OriginalIP {
+			queueScheduledRevert(m.ID, targetIP, revertAfter)
+		}
 	}
 }
 
-func CheckMonitor(m *Monitor) {
+// Pending reverts of scheduled switches. In memory only: a revert due while
+// the service is down is not replayed on startup.
+var (
+	revertMu     sync.Mutex
+	revertTimers = map[uint]*time.Timer{}
+)
+
+// queueScheduledRevert replaces any pending revert of the monitor.
+func queueScheduledRevert(monitorID uint, switchedIP string, after time.Duration) {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if t := revertTimers[monitorID]; t != nil {
+		t.Stop()
+	}
+	revertTimers[monitorID] = time.AfterFunc(after, func() {
+		revertMu.Lock()
+		delete(revertTimers, monitorID)
+		revertMu.Unlock()
+		ScheduledRevert(monitorID, switchedIP)
+	})
+	slog.Info("Scheduled revert queued", "monitor_id", monitorID, "after", after)
+}
+
+// cancelScheduledRevert drops a pending revert, e.g. when a real failover
+// takes over the record.
+func cancelScheduledRevert(monitorID uint) {
+	revertMu.Lock()
+	defer revertMu.Unlock()
+	if t := revertTimers[monitorID]; t != nil {
+		t.Stop()
+		delete(revertTimers, monitorID)
+		slog.Info("Scheduled revert cancelled", "monitor_id", monitorID)
+	}
+}
+
+// ScheduledRevert switches back to the original IP, unless the record has
+// moved off switchedIP since (failover, manual action, another schedule).
+func ScheduledRevert(monitorID uint, switchedIP string) {
+	unlock := lockMonitor(monitorID)
+	defer unlock()
+
+	var m Monitor
+	if err := DB.First(&m, monitorID).Error; err != nil {
+		slog.Warn("ScheduledRevert: monitor not found", "monitor_id", monitorID)
+		return
+	}
+	if m.Status == "Down" || m.CurrentIP != switchedIP {
+		slog.Info("Skipping scheduled revert because the record was switched since", "monitor_id", m.ID, "monitor", m.Name, "current_ip", m.CurrentIP)
+		return
+	}
+	if m.CircuitOpen() {
+		slog.Info("Skipping scheduled revert because DNS updates are suspended", "monitor_id", m.ID, "monitor", m.Name)
+		return
+	}
+
+	slog.Info("Executing scheduled revert", "monitor_id", m.ID, "monitor", m.Name, "event", "scheduled_switch", "old_ip", m.CurrentIP, "new_ip", m.OriginalIP)
+
+	oldIP := m.CurrentIP
+	if UpdateCloudflareDNS(&m, m.OriginalIP) {
+		m.CurrentIP = m.OriginalIP
+		m.FailCount = 0
+		m.SuccCount = 0
+		DB.Model(&m).Select("CurrentIP", "FailCount", "SuccCount").Updates(&m)
+		if m.Notifies(NotifyScheduledSwitch) {
+			SendNotification(NotifyEvent{Kind: NotifyScheduledSwitch, Monitor: m.Name, OldIP: oldIP, NewIP: m.OriginalIP})
+		}
+		RecordEvent(Event{MonitorID: m.ID, Type: "scheduled_switch", Message: "Scheduled revert", OldIP: oldIP, NewIP: m.OriginalIP, Actor: "system"})
+	}
+}
+
+// CheckMonitor runs one check and applies the result. It reports whether
+// the check passed; false too when the monitor is gone or misconfigured,
+// or outside its active window, where it isn't checked at all.
+func CheckMonitor(m *Monitor) bool {
 	// Re-fetch monitor from DB to get latest state (avoid stale state in closure)
 	var currentMonitor Monitor
-	if err := DB.First(&currentMonitor, m.ID).Error; err != nil {
-		return // Monitor might be deleted
+	if err := DB.Preload("Backups").First(&currentMonitor, m.ID).Error; err != nil {
+		return false // Monitor might be deleted
 	}
 	*m = currentMonitor
 	m.ApplyDefaults() // Ensure defaults are applied even if DB has zero values
 
+	// Seeded monitors skip API validation; don't run a broken config
+	if err := m.Validate(); err != nil {
+		unlock := lockMonitor(m.ID)
+		defer unlock()
+		m.LastError = "config: " + err.Error()
+		m.LastErrorAt = time.Now()
+		markMonitorError(m, m.LastError)
+		DB.Model(m).Select("Status", "LastError", "LastErrorAt").Updates(m)
+		return false
+	}
+
+	// Outside the active window: no check, and a streak counted before the
+	// window closed doesn't carry over into the next one
+	if !m.InWindow(time.Now()) {
+		if m.FailCount != 0 || m.SuccCount != 0 {
+			unlock := lockMonitor(m.ID)
+			m.FailCount = 0
+			m.SuccCount = 0
+			DB.Model(m).Select("FailCount", "SuccCount").Updates(m)
+			unlock()
+		}
+		return false
+	}
+
 	// We ALWAYS want to check the OriginalIP (Primary Service) availability
 	// This prevents DNS caching issues and ensures we are monitoring the actual backend.
 	// Even if we are currently "Down" (using Backup), we check Primary to see if it recovered.
-	checkTarget := m.OriginalIP
-	if checkTarget == "" {
-		checkTarget = m.Target // Fallback if no specific IP configured
+	probe := probeTarget(m, m.Type, m.Target, m.Port, m.OriginalIP)
+
+	// Composite health: fold in the extra checks per CheckMode
+	var checks []MonitorCheck
+	DB.Where("monitor_id = ?", m.ID).Find(&checks)
+	if len(checks) > 0 {
+		probe = combineChecks(m, probe, checks)
 	}
+	isUp := probe.Up
 
-	isUp := false
-	switch m.Type {
-	case "ping":
-		isUp = CheckPing(checkTarget, m.Timeout)
-	case "http", "https":
-		// Pass OriginalIP to force connection to Primary
-		isUp = CheckHTTP(m.Target, m.Timeout, m.OriginalIP)
-	default:
-		isUp = CheckPing(checkTarget, m.Timeout) // Default
+	// The backup is only probed while the primary is failing or the record
+	// points at the backup, so healthy monitors cost nothing extra
+	backupStatus := ""
+	if m.CheckBackup && m.BackupIP != "" && (!isUp || m.ServingStatus() == "Down") {
+		backupStatus = "Up"
+		if backup := probeTarget(m, m.Type, m.Target, m.Port, m.BackupIP); !backup.Up {
+			backupStatus = "Down"
+			slog.Debug("Backup check failed", "monitor_id", m.ID, "backup_ip", m.BackupIP, "error", backup.Err)
+		}
+	}
+
+	// Where a failover would go, empty when nothing healthy is known. With
+	// extra backups the candidates are probed once the next failure would
+	// trigger the failover, and the most preferred healthy one wins.
+	failoverIP := m.BackupIP
+	if backupStatus == "Down" {
+		failoverIP = ""
+	}
+	if len(m.Backups) > 0 && !isUp && m.ServingStatus() == "Normal" && m.failoverDue(m.FailCount+1, time.Now()) {
+		failoverIP = firstHealthyBackup(m, m.BackupIPs())
+	}
+	// promote_backups: while on a backup, look for a more preferred one that
+	// is healthy again
+	var promoteIP string
+	if m.PromoteBackups && len(m.Backups) > 0 && m.ServingStatus() == "Down" {
+		ips := m.BackupIPs()
+		if i := slices.Index(ips, m.CurrentIP); i > 0 {
+			promoteIP = firstHealthyBackup(m, ips[:i])
+		}
+	}
+
+	// The check itself runs unlocked; state is re-read under the lock because a
+	// scheduled switch or manual action may have changed it in the meantime.
+	unlock := lockMonitor(m.ID)
+	defer unlock()
+
+	if err := DB.Preload("Backups").First(&currentMonitor, m.ID).Error; err != nil {
+		return false
+	}
+	*m = currentMonitor
+	m.ApplyDefaults()
+
+	if !m.CircuitOpenUntil.IsZero() && !m.CircuitOpen() {
+		clearMonitorError(m, "Cooldown expired, retrying DNS updates", "system")
+	} else if m.Status == "Error" && strings.HasPrefix(m.LastError, "config: ") {
+		clearMonitorError(m, "Config is valid again", "system")
+	}
+	if !isUp {
+		m.LastError = "check: " + probe.Err.Error()
+		m.LastErrorAt = time.Now()
+	}
+	if !m.CheckBackup {
+		m.BackupStatus = ""
+	} else if backupStatus != "" {
+		m.BackupStatus = backupStatus
 	}
 
 	// Logic for Failover
-	if isUp {
+	switch {
+	case m.CircuitOpen():
+		// DNS updates suspended: only the check result is recorded
+	case isUp:
+		clearNoHealthy(m.ID)
 		HandleSuccess(m)
-	} else {
-		HandleFailure(m)
+	default:
+		HandleFailure(m, failoverIP)
+	}
+	if promoteIP != "" && promoteIP != m.CurrentIP && m.ServingStatus() == "Down" && !m.CircuitOpen() {
+		promoteBackup(m, promoteIP)
 	}
 
 	// Update DB - Only update dynamic state fields to avoid overwriting configuration changes
 	m.LastCheck = time.Now()
+	result := CheckResult{
+		MonitorID:  m.ID,
+		Up:         isUp,
+		Latency:    float64(probe.Latency) / float64(time.Millisecond),
+		StatusCode: probe.StatusCode,
+	}
+	if ping := probe.Ping; ping != nil {
+		m.PacketLoss, m.RTTAvg, m.RTTMax = ping.Loss, ping.RTTAvg, ping.RTTMax
+		result.PacketLoss, result.RTTAvg, result.RTTMax = ping.Loss, ping.RTTAvg, ping.RTTMax
+	}
 	// Using Select ensures we only update the fields we care about, protecting Config fields.
 	// Note: We need to use Updates with a struct or map. Since m is a struct and we set fields on it,
 	// Updates(m) works but we must combine it with Select to restrict columns.
-	DB.Model(m).Select("Status", "LastCheck", "FailCount", "SuccCount", "CurrentIP").Updates(m)
+	DB.Model(m).Select("Status", "LastCheck", "FailCount", "FirstFailAt", "SuccCount", "CurrentIP", "PacketLoss", "RTTAvg", "RTTMax", "LastError", "LastErrorAt", "DNSFailCount", "CircuitOpenUntil", "BackupStatus", "FailoverAt").Updates(m)
+	RecordCheck(result)
+	return isUp
+}
+
+// probeTarget runs one health check of the given type. When connectIP is set
+// the check connects there instead of resolving target.
+func probeTarget(m *Monitor, checkType, target string, port int, connectIP string) ProbeResult {
+	connectIP = unbracketIP(connectIP)
+	checkTarget := connectIP
+	if checkTarget == "" {
+		checkTarget = target // Fallback if no specific IP configured
+	}
+
+	// Fall back to a port embedded in target ("host:port") when none is set
+	if checkType == "tcp" && port == 0 {
+		if _, p, err := net.SplitHostPort(target); err == nil {
+			port, _ = strconv.Atoi(p)
+		}
+	}
+
+	key := m.probeKey(checkType, target, port, checkTarget)
+	probe, shared := sharedProbe(key)
+	if !shared {
+		switch checkType {
+		case "ping":
+			probe = CheckPing(checkTarget, m.Timeout, m.PingOptions())
+		case "http", "https":
+			// Pass connectIP to force connection to Primary
+			probe = CheckHTTP(target, port, m.Timeout, connectIP, m.DialOptions(), m.TLSOptions(), m.HTTPOptions())
+		case "tcp":
+			probe = CheckTCP(checkTarget, port, m.Timeout, m.DialOptions())
+		case "grpc":
+			// Like HTTP: keep target for TLS server name, connect to connectIP
+			probe = CheckGRPC(target, port, m.Timeout, connectIP, m.DialOptions(), m.GRPCService, m.GRPCTLS, m.TLSOptions())
+		default:
+			probe = CheckPing(checkTarget, m.Timeout, m.PingOptions()) // Default
+		}
+		storeSharedProbe(key, probe)
+	} else {
+		slog.Debug("Reusing shared check result", "monitor_id", m.ID, "type", checkType, "target", checkTarget, "port", port)
+	}
+
+	// Degraded links: high loss counts as a failure even if some replies came back
+	if ping := probe.Ping; ping != nil && probe.Up && m.MaxPacketLoss > 0 && ping.Loss > m.MaxPacketLoss {
+		slog.Debug("Ping loss above threshold", "monitor_id", m.ID, "target", checkTarget, "loss", ping.Loss, "max_loss", m.MaxPacketLoss)
+		probe.Up = false
+		probe.Err = fmt.Errorf("packet loss %.0f%% above %.0f%%", ping.Loss, m.MaxPacketLoss)
+	}
+	// Slow but reachable backends: treat high latency like a failed check
+	if limit := time.Duration(m.MaxLatencyMs) * time.Millisecond; probe.Ping == nil && probe.Up && limit > 0 && probe.Latency > limit {
+		slog.Debug("Latency above threshold", "monitor_id", m.ID, "target", checkTarget, "latency_ms", probe.Latency.Milliseconds(), "max_latency_ms", m.MaxLatencyMs)
+		probe.Up = false
+		probe.Err = fmt.Errorf("latency %dms above %dms", probe.Latency.Milliseconds(), m.MaxLatencyMs)
+	}
+	return probe
+}
+
+// probeKey identifies a check by everything that affects its raw result, so
+// monitors only share results of identical checks. Per-monitor thresholds
+// (packet loss, latency) are applied after the lookup and aren't part of it.
+type probeKey struct {
+	Type        string
+	Target      string // Host name for http/https/grpc, empty for ping/tcp
+	Addr        string // IP (or host) actually connected to
+	Port        int
+	Timeout     int
+	Dial        DialOptions
+	TLS         TLSOptions
+	HTTP        HTTPOptions
+	GRPCService string
+	GRPCTLS     bool
+	Ping        PingOptions
+}
+
+func (m *Monitor) probeKey(checkType, target string, port int, checkTarget string) probeKey {
+	key := probeKey{Type: checkType, Addr: checkTarget, Port: port, Timeout: m.Timeout, Dial: m.DialOptions()}
+	switch checkType {
+	case "http", "https":
+		key.Target, key.TLS = target, m.TLSOptions()
+		key.HTTP = m.HTTPOptions()
+	case "grpc":
+		key.Target, key.TLS = target, m.TLSOptions()
+		key.GRPCService, key.GRPCTLS = m.GRPCService, m.GRPCTLS
+	case "tcp":
+		// Address and port only
+	default:
+		key.Ping = m.PingOptions()
+	}
+	return key
+}
+
+type sharedResult struct {
+	probe ProbeResult
+	at    time.Time
+}
+
+// Recent raw check results, reused for checks.share_ttl seconds by monitors
+// probing the same endpoint (apex, www and api on one server)
+var (
+	sharedProbesMu sync.Mutex
+	sharedProbes   = map[probeKey]sharedResult{}
+)
+
+// sharedProbe returns a result younger than checks.share_ttl. Sharing is off
+// when the TTL is 0.
+func sharedProbe(key probeKey) (ProbeResult, bool) {
+	ttl := time.Duration(GetConfig().Checks.ShareTTL) * time.Second
+	if ttl <= 0 {
+		return ProbeResult{}, false
+	}
+	sharedProbesMu.Lock()
+	defer sharedProbesMu.Unlock()
+	r, ok := sharedProbes[key]
+	if !ok || time.Since(r.at) >= ttl {
+		return ProbeResult{}, false
+	}
+	return r.probe, true
+}
+
+func storeSharedProbe(key probeKey, probe ProbeResult) {
+	ttl := time.Duration(GetConfig().Checks.ShareTTL) * time.Second
+	if ttl <= 0 {
+		return
+	}
+	sharedProbesMu.Lock()
+	defer sharedProbesMu.Unlock()
+	now := time.Now()
+	for k, r := range sharedProbes {
+		if now.Sub(r.at) >= ttl {
+			delete(sharedProbes, k)
+		}
+	}
+	sharedProbes[key] = sharedResult{probe: probe, at: now}
+}
+
+// combineChecks runs the extra checks in parallel and combines them with the
+// main check: with CheckMode "any" one passing check is enough, otherwise
+// all must pass. Latency and ping details stay those of the main check.
+func combineChecks(m *Monitor, main ProbeResult, checks []MonitorCheck) ProbeResult {
+	results := make([]ProbeResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		checkType := c.Type
+		if checkType == "" {
+			checkType = m.Type
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = probeTarget(m, checkType, c.Target, c.Port, "")
+		}()
+	}
+	wg.Wait()
+
+	var failed []string
+	if !main.Up {
+		failed = append(failed, m.Target+": "+main.Err.Error())
+	}
+	for i, r := range results {
+		if !r.Up {
+			failed = append(failed, checks[i].Target+": "+r.Err.Error())
+		}
+	}
+
+	total := len(checks) + 1
+	combined := main
+	combined.Up = len(failed) == 0
+	if m.CheckMode == "any" {
+		combined.Up = len(failed) < total
+	}
+	combined.Err = nil
+	if !combined.Up {
+		combined.Err = fmt.Errorf("%d/%d checks failed: %s", len(failed), total, strings.Join(failed, "; "))
+	}
+	return combined
+}
+
+// ProbeResult is the outcome of a single health check.
+type ProbeResult struct {
+	Up         bool
+	Latency    time.Duration
+	StatusCode int         // HTTP only
+	Err        error       // Why the check failed, nil when Up
+	Ping       *PingResult // Ping only: loss and RTT details
+}
+
+func probeFailed(start time.Time, err error) ProbeResult {
+	return ProbeResult{Latency: time.Since(start), Err: err}
+}
+
+// Sent by http/https checks unless checks.user_agent or the monitor's
+// user_agent says otherwise
+const defaultUserAgent = "CFGuard-Monitor/1.0"
+
+// HTTPOptions sets the headers of http/https checks and what the response
+// must look like beyond its status.
+type HTTPOptions struct {
+	UserAgent   string
+	RequestID   bool   // Send a unique X-Request-ID
+	ContentType string // Expected media type, "type/*" matches any subtype
+	MinBytes    int64  // Minimum body length
 }
 
-func CheckHTTP(target string, timeout int, forceIP string) bool {
+// contentTypeMatches compares the media type of a Content-Type header with
+// the expected one, ignoring parameters such as charset.
+func contentTypeMatches(header, expected string) bool {
+	got, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return false
+	}
+	want, _, _ := mime.ParseMediaType(expected)
+	if prefix, ok := strings.CutSuffix(want, "/*"); ok {
+		return strings.HasPrefix(got, prefix+"/")
+	}
+	return got == want
+}
+
+// newRequestID returns a random 128-bit hex ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CheckHTTP is up if the target answered with a 2xx/3xx status.
+func CheckHTTP(target string, port int, timeout int, forceIP string, dialOpts DialOptions, tlsOpts TLSOptions, httpOpts HTTPOptions) ProbeResult {
+	// A bare IPv6 target needs brackets to form a URL
+	if ip := net.ParseIP(target); ip != nil && ip.To4() == nil {
+		target = "[" + target + "]"
+	}
 	if !strings.HasPrefix(target, "http") {
 		target = "http://" + target
 	}
+	// Apply the monitor port unless the URL already carries one
+	if port > 0 {
+		if u, err := url.Parse(target); err == nil && u.Port() == "" {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+			target = u.String()
+		}
+	}
 
-	client := getHTTPClient(forceIP, timeout)
+	forceIP = unbracketIP(forceIP)
+	if err := validateConnectIP(forceIP); err != nil {
+		return ProbeResult{Err: err}
+	}
+	client, err := getHTTPClient(forceIP, timeout, dialOpts, tlsOpts)
+	if err != nil {
+		slog.Warn("Failed to create HTTP client", "target", target, "error", err)
+		return ProbeResult{Err: err}
+	}
 
 	// Use a context for safety, though client.Timeout handles it too.
 	// client.Timeout is "hard" timeout.
 	req, err := http.NewRequest("GET", target, nil)
 	if err != nil {
-		log.Printf("Failed to create HTTP request for %s: %v", target, err)
-		return false
+		slog.Warn("Failed to create HTTP request", "target", target, "error", err)
+		return ProbeResult{Err: err}
+	}
+	req.Header.Set("User-Agent", httpOpts.UserAgent)
+	var requestID string
+	if httpOpts.RequestID {
+		requestID = newRequestID()
+		req.Header.Set("X-Request-ID", requestID)
 	}
-	// Add a user agent
-	req.Header.Set("User-Agent", "CFGuard-Monitor/1.0")
 
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		if AppConfig.Server.Debug {
-			log.Printf("HTTP Check failed for %s: %v", target, err)
-		}
-		return false
+		slog.Debug("HTTP check failed", "target", target, "request_id", requestID, "error", err)
+		return probeFailed(start, sourceError(err, dialOpts.SourceIP))
 	}
 	defer resp.Body.Close()
-	// Read a bit of body to ensure connection can be reused (drain body)
-	io.Copy(io.Discard, resp.Body)
+	// Read the whole body: drains it so the connection can be reused, and
+	// gives the length for MinBytes
+	size, readErr := io.Copy(io.Discard, resp.Body)
+
+	res := ProbeResult{Up: true, Latency: time.Since(start), StatusCode: resp.StatusCode}
+	switch {
+	case resp.StatusCode < 200 || resp.StatusCode >= 400:
+		res.Err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	case httpOpts.ContentType != "" && !contentTypeMatches(resp.Header.Get("Content-Type"), httpOpts.ContentType):
+		res.Err = fmt.Errorf("content type %q, expected %s", resp.Header.Get("Content-Type"), httpOpts.ContentType)
+	case httpOpts.MinBytes > 0 && readErr != nil:
+		res.Err = fmt.Errorf("reading response body: %v", readErr)
+	case size < httpOpts.MinBytes:
+		res.Err = fmt.Errorf("response body %d bytes, expected at least %d", size, httpOpts.MinBytes)
+	}
+	if res.Err != nil {
+		slog.Debug("HTTP check response error", "target", target, "request_id", requestID, "status", resp.StatusCode, "error", res.Err)
+		res.Up = false
+	}
+	return res
+}
+
+// CheckTCP is up if a TCP connection could be established.
+func CheckTCP(host string, port int, timeout int, dialOpts DialOptions) ProbeResult {
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(unbracketIP(host), strconv.Itoa(port))
+	}
 
-	success := resp.StatusCode >= 200 && resp.StatusCode < 400
-	if !success && AppConfig.Server.Debug {
-		log.Printf("HTTP Check status code error for %s: %d", target, resp.StatusCode)
+	start := time.Now()
+	conn, err := dialOpts.dialer(time.Duration(timeout)*time.Second).Dial("tcp", addr)
+	if err != nil {
+		slog.Debug("TCP check failed", "target", addr, "error", err)
+		return probeFailed(start, sourceError(err, dialOpts.SourceIP))
+	}
+	conn.Close()
+	return ProbeResult{Up: true, Latency: time.Since(start)}
+}
+
+// Interval between packets within one attempt (minimum for unprivileged users)
+const pingPacketInterval = 200 * time.Millisecond
+
+// PingOptions controls how a ping check is performed.
+//
+// Each attempt runs one ping command sending Packets packets and waiting up
+// to the monitor Timeout for replies, so a dead host costs roughly
+// Timeout + (Packets-1)*200ms per attempt. Attempts stop at the first one
+// with any reply and are separated by Delay. The whole check is bounded by a
+// deadline covering all attempts plus 2s of slack; keep it below Interval.
+type PingOptions struct {
+	Attempts int
+	Packets  int
+	Delay    time.Duration
+	Source   string // Source address (-I / -S), empty = OS default
+}
+
+// pingArgs builds the ping command line for one attempt on goos.
+func pingArgs(goos, host string, timeout int, opts PingOptions) []string {
+	countStr := strconv.Itoa(opts.Packets)
+	if goos == "windows" {
+		args := []string{"-n", countStr, "-w", strconv.Itoa(timeout * 1000)}
+		if opts.Source != "" {
+			args = append(args, "-S", opts.Source)
+		}
+		return append(args, host)
+	}
+	// iputils handles both IPv4 and IPv6 literals with plain "ping"
+	args := []string{"-c", countStr}
+	if opts.Packets > 1 {
+		// 200ms is the smallest interval allowed for unprivileged users, and
+		// some builds reject -i entirely, so it's only sent when it matters
+		args = append(args, "-i", "0.2")
 	}
-	return success
+	args = append(args, "-W", strconv.Itoa(timeout))
+	if opts.Source != "" {
+		args = append(args, "-I", opts.Source)
+	}
+	return append(args, host)
+}
+
+// deadline is the overall time budget for a ping check with these options.
+func (o PingOptions) deadline(timeout int) time.Duration {
+	perAttempt := time.Duration(timeout)*time.Second + time.Duration(o.Packets-1)*pingPacketInterval
+	return time.Duration(o.Attempts)*perAttempt + time.Duration(o.Attempts-1)*o.Delay + 2*time.Second
 }
 
-func CheckPing(host string, timeout int) bool {
+// PingResult holds the packet statistics of one ping attempt.
+type PingResult struct {
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"`    // Percent
+	RTTAvg   float64 `json:"rtt_avg"` // ms
+	RTTMax   float64 `json:"rtt_max"` // ms
+}
+
+var (
+	// iputils: "5 packets transmitted, 4 received"; busybox: "... 4 packets received"
+	pingCountRe = regexp.MustCompile(`(\d+) packets transmitted, (\d+) (?:packets )?received`)
+	// "rtt min/avg/max/mdev = 0.1/0.2/0.3/0.0 ms" or "round-trip min/avg/max = ..."
+	pingRTTRe = regexp.MustCompile(`= [\d.]+/([\d.]+)/([\d.]+)`)
+	// Windows: "Sent = 4, Received = 3" and "Maximum = 2ms, Average = 1ms"
+	pingWinCountRe = regexp.MustCompile(`Sent = (\d+), Received = (\d+)`)
+	pingWinRTTRe   = regexp.MustCompile(`Maximum = (\d+)ms, Average = (\d+)ms`)
+)
+
+// parsePingOutput extracts packet counts and RTTs from ping's summary lines.
+// Returns false if the output isn't recognised (e.g. localized Windows ping).
+func parsePingOutput(out string) (PingResult, bool) {
+	var res PingResult
+	if m := pingCountRe.FindStringSubmatch(out); m != nil {
+		res.Sent, _ = strconv.Atoi(m[1])
+		res.Received, _ = strconv.Atoi(m[2])
+		if r := pingRTTRe.FindStringSubmatch(out); r != nil {
+			res.RTTAvg, _ = strconv.ParseFloat(r[1], 64)
+			res.RTTMax, _ = strconv.ParseFloat(r[2], 64)
+		}
+	} else if m := pingWinCountRe.FindStringSubmatch(out); m != nil {
+		res.Sent, _ = strconv.Atoi(m[1])
+		res.Received, _ = strconv.Atoi(m[2])
+		if r := pingWinRTTRe.FindStringSubmatch(out); r != nil {
+			res.RTTMax, _ = strconv.ParseFloat(r[1], 64)
+			res.RTTAvg, _ = strconv.ParseFloat(r[2], 64)
+		}
+	} else {
+		return res, false
+	}
+	if res.Sent > 0 {
+		res.Loss = float64(res.Sent-res.Received) * 100 / float64(res.Sent)
+	}
+	return res, true
+}
+
+// CheckGRPC calls the standard grpc.health.v1.Health/Check RPC and is up
+// on SERVING. An empty service name checks the server as a whole.
+func CheckGRPC(target string, port int, timeout int, forceIP string, dialOpts DialOptions, service string, useTLS bool, tlsOpts TLSOptions) ProbeResult {
+	addr := target
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		if port == 0 {
+			port = 443
+			if !useTLS {
+				port = 80
+			}
+		}
+		addr = net.JoinHostPort(unbracketIP(target), strconv.Itoa(port))
+	}
+	forceIP = unbracketIP(forceIP)
+	if err := validateConnectIP(forceIP); err != nil {
+		return ProbeResult{Err: err}
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	creds := insecure.NewCredentials()
+	if useTLS {
+		tlsConfig, err := tlsOpts.config(host)
+		if err != nil {
+			slog.Warn("Failed to create gRPC TLS config", "target", addr, "error", err)
+			return ProbeResult{Err: err}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	dialer := dialOpts.dialer(0)
+	conn, err := grpc.NewClient("passthrough:///"+addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, a string) (net.Conn, error) {
+			// If forceIP is provided, override DNS resolution but keep the port
+			if forceIP != "" {
+				if _, p, err := net.SplitHostPort(a); err == nil {
+					a = net.JoinHostPort(forceIP, p)
+				}
+			}
+			return dialer.DialContext(ctx, "tcp", a)
+		}),
+	)
+	if err != nil {
+		slog.Warn("Failed to create gRPC client", "target", addr, "error", err)
+		return ProbeResult{Err: err}
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		slog.Debug("gRPC health check failed", "target", addr, "service", service, "error", err)
+		return probeFailed(start, err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		slog.Debug("gRPC health check not serving", "target", addr, "service", service, "status", resp.Status.String())
+		return probeFailed(start, fmt.Errorf("health status %s", resp.Status.String()))
+	}
+	return ProbeResult{Up: true, Latency: time.Since(start)}
+}
+
+func CheckPing(host string, timeout int, opts PingOptions) ProbeResult {
 	// Simple Ping implementation using OS command
 	// In production, might want to use a library or raw socket, but permissions can be tricky in docker.
 	// OS command is safer for unprivileged containers if ping is installed.
 
-	// Use context with timeout slightly larger than ping timeout to kill hung processes
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout+2)*time.Second)
+	// Use a context covering all attempts to kill hung processes
+	ctx, cancel := context.WithTimeout(context.Background(), opts.deadline(timeout))
 	defer cancel()
 
-	// Try 3 times, if 1 success then OK. This avoids flakiness.
-	success := false
-	for i := 0; i < 3; i++ {
-		var cmd *exec.Cmd
-		timeoutStr := strconv.Itoa(timeout)
-
-		if runtime.GOOS == "windows" {
-			cmd = exec.CommandContext(ctx, "ping", "-n", "1", "-w", strconv.Itoa(timeout*1000), host)
-		} else {
-			// Check if IPv6
-			cmdName := "ping"
-			// Simple heuristic: if it contains a colon, treat as IPv6.
-			// Note: If host is a domain, this won't trigger, which is fine as 'ping' usually handles domains.
-			// But for explicit IPv6 literals, we might need ping6 on some older systems.
-			// On Alpine with iputils, ping handles both.
-			if strings.Contains(host, ":") {
-				// Try ping6 if available, or rely on ping auto-detect
-				// For compatibility, let's stick to 'ping' as iputils usually handles it.
-				// However, explicitly using -6 might be safer if we want to force it?
-				// Let's just use "ping" as it's standard now.
-			}
-			cmd = exec.CommandContext(ctx, cmdName, "-c", "1", "-W", timeoutStr, host)
+	// Retry up to opts.Attempts times, if 1 success then OK. This avoids flakiness.
+	// Metrics come from the last attempt.
+	res := ProbeResult{Err: fmt.Errorf("ping timed out")}
+	for i := 0; i < opts.Attempts; i++ {
+		if i > 0 {
+			time.Sleep(opts.Delay)
 		}
 
-		// Hide output to keep logs clean
-		cmd.Stdout = io.Discard
+		cmd := exec.CommandContext(ctx, "ping", pingArgs(runtime.GOOS, host, timeout, opts)...)
+
+		// Keep stderr out of the logs; stdout carries the summary we parse
 		cmd.Stderr = io.Discard
+		start := time.Now()
+		out, err := cmd.Output()
+		elapsed := time.Since(start)
+
+		stats, ok := parsePingOutput(string(out))
+		if !ok {
+			// Unrecognised output: fall back to the exit code
+			stats = PingResult{Sent: opts.Packets, Loss: 100}
+			if err == nil {
+				stats = PingResult{Sent: opts.Packets, Received: opts.Packets}
+			}
+		}
 
-		err := cmd.Run()
-		if err == nil {
-			success = true
+		res = ProbeResult{Up: stats.Received > 0, Latency: elapsed, Ping: &stats}
+		if stats.RTTAvg > 0 {
+			res.Latency = time.Duration(stats.RTTAvg * float64(time.Millisecond))
+		}
+		if !res.Up {
+			if ok || err == nil {
+				res.Err = fmt.Errorf("no reply from %s (%d packets sent)", host, stats.Sent)
+			} else {
+				res.Err = fmt.Errorf("ping failed: %v", err)
+			}
+		}
+		if res.Up || ctx.Err() != nil {
 			break
 		}
-		time.Sleep(500 * time.Millisecond)
 	}
-	return success
+	return res
 }
 
 func HandleSuccess(m *Monitor) {
-	if m.Status == "Down" {
+	if m.ServingStatus() == "Down" {
 		m.SuccCount++
 
 		threshold := m.RecoveryRetries
@@ -295,23 +1175,34 @@ func HandleSuccess(m *Monitor) {
 			}
 		}
 
-		if m.SuccCount >= threshold {
+		// Dampen flapping: a primary that looks up right after failing over
+		// keeps counting, but recovery waits for the cooldown to pass
+		if until := m.FailoverAt.Add(time.Duration(m.FailoverCooldown) * time.Second); m.SuccCount >= threshold && time.Now().Before(until) {
+			slog.Debug("Recovery held by failover cooldown", "monitor_id", m.ID, "monitor", m.Name, "until", until)
+		} else if m.SuccCount >= threshold {
 			// Restore
-			log.Printf("Monitor %s restored!", m.Name)
+			slog.Info("Monitor restored", "monitor_id", m.ID, "monitor", m.Name, "event", "recovery", "old_ip", m.CurrentIP, "new_ip", m.OriginalIP)
 
 			// Try to switch DNS first
-			if UpdateCloudflareDNS(m, m.OriginalIP) {
+			oldIP := m.CurrentIP
+			res := UpdateCloudflareDNSResult(m, m.OriginalIP)
+			if res.Success {
 				m.Status = "Normal"
 				m.SuccCount = 0
+				m.DNSFailCount = 0
 				m.CurrentIP = m.OriginalIP
 
 				// Send Notification
-				SendNotification(fmt.Sprintf("✅ 服务恢复: %s 已切回主 IP %s", m.Name, m.OriginalIP))
+				if m.Notifies(NotifyRecovery) {
+					SendNotification(NotifyEvent{Kind: NotifyRecovery, Monitor: m.Name, OldIP: oldIP, NewIP: m.OriginalIP})
+				}
+				RecordEvent(Event{MonitorID: m.ID, Type: "recovery", Message: "Primary recovered, switched back", OldIP: oldIP, NewIP: m.OriginalIP, Actor: "system"})
 			} else {
-				log.Printf("Monitor %s restored but failed to switch DNS to %s", m.Name, m.OriginalIP)
+				slog.Error("Monitor restored but failed to switch DNS", "monitor_id", m.ID, "monitor", m.Name, "event", "recovery", "new_ip", m.OriginalIP)
 				// Reset SuccCount so we don't loop tightly, but keep Status=Down
 				// Or maybe keep SuccCount high to retry immediately?
 				// Let's keep it high.
+				dnsUpdateFailed(m, m.OriginalIP, res)
 			}
 		}
 	} else {
@@ -319,27 +1210,343 @@ func HandleSuccess(m *Monitor) {
 	}
 }
 
-func HandleFailure(m *Monitor) {
-	if m.Status == "Normal" {
+// HandleFailure counts a failed primary check and fails over to backupIP at
+// the threshold. backupIP is empty when no backup is known to be healthy
+// (CheckBackup, or every extra backup failed): the record then stays where
+// it is rather than moving to a dead IP.
+func HandleFailure(m *Monitor, backupIP string) {
+	if backupIP != "" {
+		clearNoHealthy(m.ID)
+	}
+	if m.ServingStatus() == "Normal" {
+		now := time.Now()
 		m.FailCount++
-		if m.FailCount >= m.Retries {
+		if m.FailCount == 1 {
+			m.FirstFailAt = now
+		}
+		due := m.failoverDue(m.FailCount, now)
+		if due && backupIP == "" {
+			// Nothing healthy to switch to: stay put and look again next check
+			alertNoHealthy(m)
+		} else if due {
 			// Failover
-			log.Printf("Monitor %s failed!", m.Name)
+			slog.Warn("Monitor failed", "monitor_id", m.ID, "monitor", m.Name, "event", "failover", "old_ip", m.CurrentIP, "new_ip", backupIP)
 
 			// Try to switch DNS first
-			if UpdateCloudflareDNS(m, m.BackupIP) {
+			oldIP := m.CurrentIP
+			res := UpdateCloudflareDNSResult(m, backupIP)
+			if res.Success {
 				m.Status = "Down"
 				m.FailCount = 0
-				m.CurrentIP = m.BackupIP
+				m.DNSFailCount = 0
+				m.CurrentIP = backupIP
+				m.FailoverAt = time.Now()
+				cancelScheduledRevert(m.ID)
 
 				// Send Notification
-				SendNotification(fmt.Sprintf("🚨 服务报警: %s 故障，已切换至备用 IP %s", m.Name, m.BackupIP))
+				if m.Notifies(NotifyFailover) {
+					SendNotification(NotifyEvent{Kind: NotifyFailover, Monitor: m.Name, OldIP: oldIP, NewIP: backupIP})
+				}
+				RecordEvent(Event{MonitorID: m.ID, Type: "failover", Message: "Primary failed, switched to backup", OldIP: oldIP, NewIP: backupIP, Actor: "system"})
 			} else {
-				log.Printf("Monitor %s failed but failed to switch DNS to %s", m.Name, m.BackupIP)
+				slog.Error("Monitor failed but failed to switch DNS", "monitor_id", m.ID, "monitor", m.Name, "event", "failover", "new_ip", backupIP)
 				// Keep status as Normal so we retry next time
+				dnsUpdateFailed(m, backupIP, res)
 			}
 		}
 	} else {
+		// Recovery needs RecoveryRetries successes in a row: a flapping
+		// primary starts over on every failure
 		m.SuccCount = 0
+		if backupIP == "" {
+			alertNoHealthy(m)
+		}
 	}
 }
+
+// firstHealthyBackup probes ips in order and returns the first that passes
+// the monitor's check, or "" when none does.
+func firstHealthyBackup(m *Monitor, ips []string) string {
+	for _, ip := range ips {
+		probe := probeTarget(m, m.Type, m.Target, m.Port, ip)
+		if probe.Up {
+			return ip
+		}
+		slog.Debug("Backup check failed", "monitor_id", m.ID, "backup_ip", ip, "error", probe.Err)
+	}
+	return ""
+}
+
+// promoteBackup moves the record from a backup to a more preferred one that
+// is healthy again. The monitor stays Down: recovery to the primary is
+// unaffected.
+func promoteBackup(m *Monitor, ip string) {
+	slog.Info("Promoting to preferred backup", "monitor_id", m.ID, "monitor", m.Name, "event", "failover", "old_ip", m.CurrentIP, "new_ip", ip)
+
+	oldIP := m.CurrentIP
+	res := UpdateCloudflareDNSResult(m, ip)
+	if !res.Success {
+		slog.Error("Failed to switch DNS to preferred backup", "monitor_id", m.ID, "monitor", m.Name, "new_ip", ip)
+		dnsUpdateFailed(m, ip, res)
+		return
+	}
+	m.CurrentIP = ip
+	m.DNSFailCount = 0
+	if m.Notifies(NotifyFailover) {
+		SendNotification(NotifyEvent{Kind: NotifyFailover, Monitor: m.Name, OldIP: oldIP, NewIP: ip})
+	}
+	RecordEvent(Event{MonitorID: m.ID, Type: "failover", Message: "Preferred backup healthy again, switched to it", OldIP: oldIP, NewIP: ip, Actor: "system"})
+}
+
+// Monitors alerted for having no healthy endpoint, so an outage of both IPs
+// is reported once rather than on every check
+var (
+	noHealthyMu      sync.Mutex
+	noHealthyAlerted = map[uint]bool{}
+)
+
+// alertNoHealthy reports that the primary and the backup both failed. The
+// record is left on CurrentIP, the last IP that was switched to.
+func alertNoHealthy(m *Monitor) {
+	noHealthyMu.Lock()
+	alerted := noHealthyAlerted[m.ID]
+	noHealthyAlerted[m.ID] = true
+	noHealthyMu.Unlock()
+	if alerted {
+		return
+	}
+
+	slog.Error("Primary and backup are both down, keeping current record", "monitor_id", m.ID, "monitor", m.Name, "event", "no_healthy", "current_ip", m.CurrentIP, "backup_ip", m.BackupIP)
+	SendNotification(NotifyEvent{Kind: NotifyNoHealthy, Monitor: m.Name, OldIP: m.CurrentIP, NewIP: m.BackupIP})
+	RecordEvent(Event{MonitorID: m.ID, Type: "no_healthy", Message: "Primary and backup are both down, keeping " + m.CurrentIP, OldIP: m.CurrentIP, NewIP: m.BackupIP, Actor: "system"})
+}
+
+func clearNoHealthy(id uint) {
+	noHealthyMu.Lock()
+	delete(noHealthyAlerted, id)
+	noHealthyMu.Unlock()
+}
+
+// dnsUpdateFailed counts a failed automatic DNS switch. A permanent failure
+// (bad credentials, missing record) puts the monitor in Error right away.
+// After circuit_breaker.threshold failures in a row the circuit opens: the
+// monitor stops switching until the cooldown expires.
+func dnsUpdateFailed(m *Monitor, targetIP string, res DNSUpdateResult) {
+	// Cancelled by shutdown: not the API's fault, retry after restart
+	if appCtx.Err() != nil {
+		return
+	}
+	m.DNSFailCount++
+	if res.Permanent() {
+		markMonitorError(m, "dns: "+res.Error)
+	}
+	breaker := GetConfig().CircuitBreaker
+	threshold := breaker.Threshold
+	if threshold <= 0 || m.DNSFailCount < threshold {
+		return
+	}
+
+	cooldown := time.Duration(breaker.Cooldown) * time.Second
+	m.Status = "Error"
+	m.FailCount = 0
+	m.SuccCount = 0
+	m.CircuitOpenUntil = time.Now().Add(cooldown)
+	slog.Error("DNS updates keep failing, suspending automatic switching", "monitor_id", m.ID, "monitor", m.Name, "event", "circuit_open", "failures", m.DNSFailCount, "cooldown", cooldown)
+	SendNotification(NotifyEvent{Kind: NotifyCircuitOpen, Monitor: m.Name, OldIP: m.CurrentIP, NewIP: targetIP})
+	RecordEvent(Event{MonitorID: m.ID, Type: "circuit_open", Message: fmt.Sprintf("%d consecutive DNS update failures, retrying after %s", m.DNSFailCount, cooldown), OldIP: m.CurrentIP, NewIP: targetIP, Actor: "system"})
+}
+
+// markMonitorError puts the monitor in Error: it can't operate until its
+// config or credentials are fixed. The reason is kept in LastError.
+func markMonitorError(m *Monitor, reason string) {
+	if m.Status == "Error" {
+		return
+	}
+	m.Status = "Error"
+	slog.Error("Monitor cannot operate", "monitor_id", m.ID, "monitor", m.Name, "event", "error", "error", reason)
+	RecordEvent(Event{MonitorID: m.ID, Type: "error", Message: reason, Actor: "system"})
+}
+
+// clearMonitorError leaves the Error status and closes the circuit. The
+// status is derived from the IP the record currently points at.
+func clearMonitorError(m *Monitor, reason, actor string) {
+	m.DNSFailCount = 0
+	m.CircuitOpenUntil = time.Time{}
+	m.Status = m.ServingStatus()
+	slog.Info("Monitor error cleared", "monitor_id", m.ID, "monitor", m.Name, "event", "error_cleared", "reason", reason)
+	RecordEvent(Event{MonitorID: m.ID, Type: "error_cleared", Message: reason, Actor: actor})
+}
+
+// ApplyExternalStatus switches a monitor immediately based on an external
+// signal, skipping the internal check and thresholds. Returns whether the
+// state changed and whether the DNS update (if any) succeeded.
+func ApplyExternalStatus(m *Monitor, isUp bool, actor string) (bool, bool) {
+	unlock := lockMonitor(m.ID)
+	defer unlock()
+	if err := DB.Preload("Backups").First(m, m.ID).Error; err != nil {
+		return false, false
+	}
+
+	if m.CircuitOpen() {
+		slog.Warn("Ignoring external signal while DNS updates are suspended", "monitor_id", m.ID, "monitor", m.Name, "actor", actor)
+		return false, false
+	}
+
+	oldIP := m.CurrentIP
+	if isUp {
+		if m.ServingStatus() != "Down" {
+			return false, true
+		}
+		slog.Info("External recovery signal", "monitor_id", m.ID, "monitor", m.Name, "event", "recovery", "old_ip", oldIP, "new_ip", m.OriginalIP, "actor", actor)
+		if !UpdateCloudflareDNS(m, m.OriginalIP) {
+			return false, false
+		}
+		m.Status = "Normal"
+		m.CurrentIP = m.OriginalIP
+		if m.Notifies(NotifyRecovery) {
+			SendNotification(NotifyEvent{Kind: NotifyRecovery, Monitor: m.Name, OldIP: oldIP, NewIP: m.OriginalIP})
+		}
+		RecordEvent(Event{MonitorID: m.ID, Type: "recovery", Message: "External recovery signal", OldIP: oldIP, NewIP: m.OriginalIP, Actor: actor})
+	} else {
+		if m.ServingStatus() == "Down" {
+			return false, true
+		}
+		slog.Warn("External failure signal", "monitor_id", m.ID, "monitor", m.Name, "event", "failover", "old_ip", oldIP, "new_ip", m.BackupIP, "actor", actor)
+		if !UpdateCloudflareDNS(m, m.BackupIP) {
+			return false, false
+		}
+		m.Status = "Down"
+		m.CurrentIP = m.BackupIP
+		m.FailoverAt = time.Now()
+		cancelScheduledRevert(m.ID)
+		if m.Notifies(NotifyFailover) {
+			SendNotification(NotifyEvent{Kind: NotifyFailover, Monitor: m.Name, OldIP: oldIP, NewIP: m.BackupIP})
+		}
+		RecordEvent(Event{MonitorID: m.ID, Type: "failover", Message: "External failure signal", OldIP: oldIP, NewIP: m.BackupIP, Actor: actor})
+	}
+
+	m.FailCount = 0
+	m.SuccCount = 0
+	m.DNSFailCount = 0
+	m.LastCheck = time.Now()
+	DB.Model(m).Select("Status", "FailCount", "SuccCount", "DNSFailCount", "CurrentIP", "LastCheck", "FailoverAt").Updates(m)
+	return true, true
+}
+
+// ReconcileMonitors syncs CurrentIP/Status with what Cloudflare actually serves.
+// DB state can drift after a crash or a manual edit in the Cloudflare dashboard.
+func ReconcileMonitors() {
+	var monitors []Monitor
+	DB.Preload("Backups").Find(&monitors)
+
+	for _, m := range monitors {
+		if m.CFZoneID == "" || m.CFRecordID == "" || m.UpdateMode == UpdateModeLBPool {
+			continue
+		}
+
+		content, err := FetchCloudflareRecordContent(appCtx, &m)
+		if err != nil {
+			slog.Warn("Reconcile: failed to fetch record", "monitor_id", m.ID, "monitor", m.Name, "error", err)
+			continue
+		}
+		if content == m.CurrentIP {
+			continue
+		}
+
+		slog.Warn("Reconcile: CurrentIP diverged from Cloudflare", "monitor_id", m.ID, "monitor", m.Name, "event", "reconcile", "old_ip", m.CurrentIP, "new_ip", content)
+
+		unlock := lockMonitor(m.ID)
+		m.CurrentIP = content
+		m.FailCount = 0
+		m.SuccCount = 0
+		switch {
+		case content == m.OriginalIP:
+			m.Status = "Normal"
+		case m.IsBackupIP(content):
+			m.Status = "Down"
+		default:
+			// Neither primary nor backup (e.g. a scheduled target): keep status as is
+		}
+		DB.Model(&m).Select("CurrentIP", "Status", "FailCount", "SuccCount").Updates(&m)
+		unlock()
+	}
+}
+
+// Live record content last alerted per monitor, so a drift that isn't
+// corrected is reported once rather than on every run
+var (
+	driftMu      sync.Mutex
+	driftAlerted = map[uint]string{}
+)
+
+// CheckDNSDrift compares each monitor's live Cloudflare record with the
+// CurrentIP cfguard last set, and alerts when something else changed it.
+// Unlike ReconcileMonitors it trusts CurrentIP: with drift_check.auto_correct
+// the record is written back.
+func CheckDNSDrift() {
+	var monitors []Monitor
+	DB.Find(&monitors)
+
+	for _, m := range monitors {
+		if m.Paused || m.CFZoneID == "" || m.CFRecordID == "" || m.CurrentIP == "" || m.UpdateMode == UpdateModeLBPool {
+			continue
+		}
+
+		content, err := FetchCloudflareRecordContent(appCtx, &m)
+		if err != nil {
+			slog.Warn("Drift check: failed to fetch record", "monitor_id", m.ID, "monitor", m.Name, "error", err)
+			continue
+		}
+		checkDrift(m, content)
+	}
+}
+
+func checkDrift(m Monitor, content string) {
+	unlock := lockMonitor(m.ID)
+	defer unlock()
+
+	// A switch that finished while the record was fetched isn't drift. The
+	// extra records are corrected along with the main one.
+	var current Monitor
+	if err := DB.Preload("Records").Preload("Backups").First(&current, m.ID).Error; err != nil || current.CurrentIP != m.CurrentIP {
+		return
+	}
+
+	driftMu.Lock()
+	alerted, seen := driftAlerted[m.ID]
+	if content == current.CurrentIP {
+		delete(driftAlerted, m.ID)
+	} else {
+		driftAlerted[m.ID] = content
+	}
+	driftMu.Unlock()
+	if content == current.CurrentIP {
+		return
+	}
+
+	expected := current.CurrentIP
+	if !GetConfig().DriftCheck.AutoCorrect {
+		if seen && alerted == content {
+			return
+		}
+		slog.Warn("DNS record changed outside cfguard", "monitor_id", m.ID, "monitor", m.Name, "event", "drift", "expected_ip", expected, "live_ip", content)
+		SendNotification(NotifyEvent{Kind: NotifyDrift, Monitor: current.Name, OldIP: expected, NewIP: content})
+		RecordEvent(Event{MonitorID: m.ID, Type: "drift", Message: "Record changed outside cfguard, expected " + expected, OldIP: expected, NewIP: content, Actor: "system"})
+		return
+	}
+
+	slog.Warn("DNS record changed outside cfguard, correcting", "monitor_id", m.ID, "monitor", m.Name, "event", "drift", "expected_ip", expected, "live_ip", content)
+	if !UpdateCloudflareDNS(&current, expected) {
+		if !seen || alerted != content {
+			SendNotification(NotifyEvent{Kind: NotifyDrift, Monitor: current.Name, OldIP: expected, NewIP: content})
+		}
+		RecordEvent(Event{MonitorID: m.ID, Type: "drift", Message: "Record changed outside cfguard, correction failed: " + current.DNSError, OldIP: expected, NewIP: content, Actor: "system"})
+		return
+	}
+
+	driftMu.Lock()
+	delete(driftAlerted, m.ID)
+	driftMu.Unlock()
+	SendNotification(NotifyEvent{Kind: NotifyDriftCorrected, Monitor: current.Name, OldIP: content, NewIP: expected})
+	RecordEvent(Event{MonitorID: m.ID, Type: "drift", Message: "Record changed outside cfguard, restored", OldIP: content, NewIP: expected, Actor: "system"})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listenIPv6 starts an HTTP server on [::1] and returns its port, skipping
+// the test where the loopback has no IPv6.
+func listenIPv6(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.Listener = ln
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestCheckHTTPIPv6(t *testing.T) {
+	port := listenIPv6(t)
+	opts := HTTPOptions{UserAgent: defaultUserAgent}
+
+	tests := []struct {
+		name    string
+		target  string
+		port    int
+		forceIP string
+	}{
+		{"bare target", "::1", port, ""},
+		{"bracketed target", "[::1]", port, ""},
+		{"url with port", "http://[::1]:" + strconv.Itoa(port) + "/", 0, ""},
+		{"force ip", "cfguard.invalid", port, "::1"},
+		{"bracketed force ip", "cfguard.invalid", port, "[::1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := CheckHTTP(tt.target, tt.port, 2, tt.forceIP, DialOptions{}, TLSOptions{}, opts)
+			if !res.Up {
+				t.Errorf("CheckHTTP(%q, force %q) down: %v", tt.target, tt.forceIP, res.Err)
+			}
+		})
+	}
+
+	if res := CheckHTTP("cfguard.invalid", port, 2, "::zz", DialOptions{}, TLSOptions{}, opts); res.Up || res.Err == nil {
+		t.Errorf("invalid force ip accepted")
+	}
+}
+
+func TestCheckTCPIPv6(t *testing.T) {
+	port := listenIPv6(t)
+	for _, host := range []string{"::1", "[::1]", "[::1]:" + strconv.Itoa(port)} {
+		if res := CheckTCP(host, port, 2, DialOptions{}); !res.Up {
+			t.Errorf("CheckTCP(%q) down: %v", host, res.Err)
+		}
+	}
+}
+
+func TestScheduledSwitchAndFailoverInParallel(t *testing.T) {
+	setupTestDB(t)
+	m := createTestMonitor(t)
+	// A closed port: every check fails, and with one retry fails over
+	m.Type = "http"
+	m.Target = "127.0.0.1:1"
+	m.OriginalIP = "127.0.0.1"
+	m.Timeout = 1
+	m.Retries = 1
+	DB.Save(&m)
+
+	var mu sync.Mutex
+	var served string // Content of the last DNS update
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		served = body.Content
+		mu.Unlock()
+		w.Write([]byte(`{"success": true, "result": {}}`))
+	})
+
+	for i := 0; i < 20; i++ {
+		DB.Model(&m).Select("Status", "CurrentIP", "FailCount", "SuccCount").
+			Updates(&Monitor{Status: "Normal", CurrentIP: m.OriginalIP})
+		served = m.OriginalIP
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ScheduledSwitch(m.ID, "3.3.3.3", 0)
+		}()
+		go func() {
+			defer wg.Done()
+			check := Monitor{ID: m.ID}
+			CheckMonitor(&check)
+		}()
+		wg.Wait()
+
+		// Whichever ran first, the failover wins: a scheduled switch after
+		// it is skipped, one before it is overridden
+		var got Monitor
+		DB.First(&got, m.ID)
+		if got.Status != "Down" || got.CurrentIP != m.BackupIP {
+			t.Fatalf("run %d: status=%s current_ip=%s, want Down on %s", i, got.Status, got.CurrentIP, m.BackupIP)
+		}
+		if served != got.CurrentIP {
+			t.Fatalf("run %d: record serves %s, monitor says %s", i, served, got.CurrentIP)
+		}
+	}
+}
+
+func TestPingArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		opts PingOptions
+		want []string
+	}{
+		{"one packet", "linux", PingOptions{Packets: 1}, []string{"-c", "1", "-W", "2", "192.0.2.1"}},
+		{"packets with interval", "linux", PingOptions{Packets: 5}, []string{"-c", "5", "-i", "0.2", "-W", "2", "192.0.2.1"}},
+		{"source", "linux", PingOptions{Packets: 1, Source: "192.0.2.9"}, []string{"-c", "1", "-W", "2", "-I", "192.0.2.9", "192.0.2.1"}},
+		{"windows", "windows", PingOptions{Packets: 3, Source: "192.0.2.9"}, []string{"-n", "3", "-w", "2000", "-S", "192.0.2.9", "192.0.2.1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pingArgs(tt.goos, "192.0.2.1", 2, tt.opts); !slices.Equal(got, tt.want) {
+				t.Errorf("pingArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPingPacketsDefault(t *testing.T) {
+	m := Monitor{Type: "ping"}
+	m.ApplyDefaults()
+	if m.PingPackets != 1 {
+		t.Errorf("default ping_packets = %d, want 1", m.PingPackets)
+	}
+}
+
+func TestCheckDriftCorrectsExtraRecords(t *testing.T) {
+	setupTestDB(t)
+	UpdateConfig(func(c *Config) { c.DriftCheck.AutoCorrect = true })
+	m := createTestMonitor(t)
+	DB.Create(&MonitorRecord{MonitorID: m.ID, Domain: "api.example.com", RecordID: "rec-api"})
+
+	var mu sync.Mutex
+	patched := map[string]string{} // Record ID to content
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Content string `json:"content"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		patched[path.Base(r.URL.Path)] = body.Content
+		mu.Unlock()
+		w.Write([]byte(`{"success": true, "result": {}}`))
+	})
+
+	checkDrift(m, "9.9.9.9")
+
+	for _, id := range []string{"rec-old", "rec-api"} {
+		if patched[id] != m.CurrentIP {
+			t.Errorf("record %s set to %q, want %s", id, patched[id], m.CurrentIP)
+		}
+	}
+}
+
+func TestRunStartupChecksSkipsInactiveWithoutStagger(t *testing.T) {
+	setupTestDB(t)
+	startupCtx, stopStartupChecks = context.WithCancel(appCtx)
+	tomorrow := strings.ToLower(time.Now().AddDate(0, 0, 1).Weekday().String()[:3])
+	for i := 0; i < 6; i++ {
+		m := Monitor{Name: "m" + strconv.Itoa(i), AccountName: "default", Type: "tcp", Target: "127.0.0.1", Port: 1,
+			OriginalIP: "127.0.0.1", BackupIP: "127.0.0.2", CurrentIP: "127.0.0.1", DNSType: "A", Status: "Normal"}
+		if i < 5 {
+			m.WindowDays = tomorrow // Outside its window
+		}
+		m.ApplyDefaults()
+		DB.Create(&m)
+	}
+
+	start := time.Now()
+	RunStartupChecks()
+	if elapsed := time.Since(start); elapsed >= startupCheckStagger {
+		t.Errorf("startup checks took %s, want the one active monitor checked without waiting", elapsed)
+	}
+	var checked int64
+	DB.Model(&Monitor{}).Where("last_check > ?", time.Time{}).Count(&checked)
+	if checked != 1 {
+		t.Errorf("%d monitors checked, want 1", checked)
+	}
+}
+
+func TestStopStartupChecks(t *testing.T) {
+	setupTestDB(t)
+	startupCtx, stopStartupChecks = context.WithCancel(appCtx)
+	fakeCloudflare(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true, "result": {}}`))
+	})
+	for i := 0; i < 10; i++ {
+		m := Monitor{Name: "m" + strconv.Itoa(i), AccountName: "default", Type: "tcp", Target: "127.0.0.1", Port: 1,
+			OriginalIP: "127.0.0.1", BackupIP: "127.0.0.2", CurrentIP: "127.0.0.1", DNSType: "A", Status: "Normal"}
+		m.ApplyDefaults()
+		DB.Create(&m)
+	}
+	checked := func() int64 {
+		var n int64
+		DB.Model(&Monitor{}).Where("last_check > ?", time.Time{}).Count(&n)
+		return n
+	}
+
+	StartStartupChecks()
+	time.Sleep(3 * startupCheckStagger / 2)
+	stopped := make(chan struct{})
+	go func() {
+		StopStartupChecks()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("StopStartupChecks didn't return")
+	}
+
+	// Whatever had started finished before StopStartupChecks returned, and
+	// nothing starts afterwards
+	n := checked()
+	if n == 0 || n >= 10 {
+		t.Errorf("%d monitors checked, want some but not all", n)
+	}
+	time.Sleep(3 * startupCheckStagger)
+	if after := checked(); after != n {
+		t.Errorf("%d monitors checked after stopping, %d before", after, n)
+	}
+}
@@ -8,40 +8,341 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"html"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/smtp"
 	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // --- Notification Service ---
 
-func SendNotification(message string) {
+// Notification event kinds
+const (
+	NotifyFailover        = "failover"
+	NotifyRecovery        = "recovery"
+	NotifyManualFailover  = "manual_failover"
+	NotifyManualRestore   = "manual_restore"
+	NotifyScheduledSwitch = "scheduled_switch"
+	NotifyCircuitOpen     = "circuit_open"
+	NotifyDigest          = "digest"
+	NotifyBatch           = "batch"
+	NotifyDrift           = "drift"
+	NotifyDriftCorrected  = "drift_corrected"
+	NotifyNoHealthy       = "no_healthy"
+	NotifyMonitorCreated  = "monitor_created"
+	NotifyMonitorUpdated  = "monitor_updated"
+	NotifyMonitorDeleted  = "monitor_deleted"
+)
+
+// NotifyEvent is a structured notification. Channels that support rich text
+// render it themselves; the rest receive the plain Text() rendering.
+type NotifyEvent struct {
+	Kind    string
+	Monitor string
+	OldIP   string
+	NewIP   string
+	Time    time.Time // Defaults to the send time
+
+	Events []NotifyEvent // Digest and batch: the grouped events, oldest first
+
+	// Monitor config changes: who made them and, for updates, what changed
+	Actor   string
+	Changes []string
+}
+
+type notifySeverity int
+
+const (
+	severityInfo notifySeverity = iota
+	severityRecovery
+	severityFailover
+)
+
+var notifySeverities = map[string]notifySeverity{
+	NotifyFailover:        severityFailover,
+	NotifyRecovery:        severityRecovery,
+	NotifyManualFailover:  severityInfo,
+	NotifyManualRestore:   severityRecovery,
+	NotifyScheduledSwitch: severityInfo,
+	NotifyCircuitOpen:     severityFailover,
+	NotifyDrift:           severityFailover,
+	NotifyDriftCorrected:  severityInfo,
+	NotifyNoHealthy:       severityFailover,
+	NotifyMonitorCreated:  severityInfo,
+	NotifyMonitorUpdated:  severityInfo,
+	NotifyMonitorDeleted:  severityInfo,
+}
+
+// NotifyTemplate is a headline plus a message body. The body may use the
+// placeholders {monitor}, {old_ip}, {new_ip} and {time}.
+type NotifyTemplate struct {
+	Headline string `yaml:"headline"`
+	Message  string `yaml:"message"`
+}
+
+// notifyCatalog holds the built-in messages per language, keyed by event kind
+var notifyCatalog = map[string]map[string]NotifyTemplate{
+	"zh": {
+		NotifyFailover:        {"🚨 服务报警", "{monitor} 故障，已切换至备用 IP {new_ip}"},
+		NotifyRecovery:        {"✅ 服务恢复", "{monitor} 已切回主 IP {new_ip}"},
+		NotifyManualFailover:  {"⚠️ 手动切换", "{monitor} 已切换至备用 IP {new_ip}"},
+		NotifyManualRestore:   {"✅ 手动恢复", "{monitor} 已切回主 IP {new_ip}"},
+		NotifyScheduledSwitch: {"🕒 计划任务", "{monitor} 已切换至 IP {new_ip}"},
+		NotifyCircuitOpen:     {"⛔ DNS 更新失败", "{monitor} 切换至 {new_ip} 连续失败，已暂停自动切换，冷却后重试"},
+		NotifyDigest:          {"🌙 静默时段通知汇总", "静默时段内共 {count} 条通知:\n{events}"},
+		NotifyBatch:           {"📋 批量状态变化", "短时间内 {count} 个监控发生切换:\n{events}"},
+		NotifyDrift:           {"⚠️ 解析被外部修改", "{monitor} 的记录被改为 {new_ip}，预期为 {old_ip}"},
+		NotifyDriftCorrected:  {"🔧 解析已纠正", "{monitor} 的记录被外部改为 {old_ip}，已恢复为 {new_ip}"},
+		NotifyNoHealthy:       {"🆘 无可用节点", "{monitor} 主 IP 与备用 IP {new_ip} 均不可用，解析保持为 {old_ip}"},
+		NotifyMonitorCreated:  {"➕ 监控已创建", "{actor} 创建了监控 {monitor}"},
+		NotifyMonitorUpdated:  {"📝 监控配置已修改", "{actor} 修改了监控 {monitor}:\n{changes}"},
+		NotifyMonitorDeleted:  {"🗑️ 监控已删除", "{actor} 删除了监控 {monitor}"},
+	},
+	"en": {
+		NotifyFailover:        {"🚨 Service Alert", "{monitor} is down, switched to backup IP {new_ip}"},
+		NotifyRecovery:        {"✅ Service Recovered", "{monitor} switched back to primary IP {new_ip}"},
+		NotifyManualFailover:  {"⚠️ Manual Failover", "{monitor} switched to backup IP {new_ip}"},
+		NotifyManualRestore:   {"✅ Manual Restore", "{monitor} switched back to primary IP {new_ip}"},
+		NotifyScheduledSwitch: {"🕒 Scheduled Switch", "{monitor} switched to IP {new_ip}"},
+		NotifyCircuitOpen:     {"⛔ DNS Update Failing", "{monitor} failed repeatedly to switch to {new_ip}, automatic switching paused until cooldown"},
+		NotifyDigest:          {"🌙 Quiet Hours Digest", "{count} notifications held during quiet hours:\n{events}"},
+		NotifyBatch:           {"📋 Multiple Monitors Switched", "{count} monitors switched in a short time:\n{events}"},
+		NotifyDrift:           {"⚠️ DNS Record Changed", "{monitor} record was changed to {new_ip} outside cfguard, expected {old_ip}"},
+		NotifyDriftCorrected:  {"🔧 DNS Record Restored", "{monitor} record was changed to {old_ip} outside cfguard, restored to {new_ip}"},
+		NotifyNoHealthy:       {"🆘 No Healthy Endpoint", "{monitor}: primary and backup IP {new_ip} are both down, record kept at {old_ip}"},
+		NotifyMonitorCreated:  {"➕ Monitor Created", "{actor} created monitor {monitor}"},
+		NotifyMonitorUpdated:  {"📝 Monitor Updated", "{actor} changed monitor {monitor}:\n{changes}"},
+		NotifyMonitorDeleted:  {"🗑️ Monitor Deleted", "{actor} deleted monitor {monitor}"},
+	},
+}
+
+// template resolves the message for this event: the built-in catalog entry
+// for the configured language (zh fallback), overridden field by field by
+// notification.templates in config.yaml.
+func (e NotifyEvent) template() NotifyTemplate {
+	msgs, ok := notifyCatalog[GetConfig().Language]
+	if !ok {
+		msgs = notifyCatalog["zh"]
+	}
+	t, ok := msgs[e.Kind]
+	if !ok {
+		t = NotifyTemplate{"ℹ️ " + e.Kind, "{monitor}: {new_ip}"}
+	}
+	if custom, ok := GetConfig().Notification.Templates[e.Kind]; ok {
+		if custom.Headline != "" {
+			t.Headline = custom.Headline
+		}
+		if custom.Message != "" {
+			t.Message = custom.Message
+		}
+	}
+	return t
+}
+
+// Severity of a digest or batch is that of its most severe event.
+func (e NotifyEvent) Severity() notifySeverity {
+	if len(e.Events) > 0 {
+		sev := severityInfo
+		for _, ev := range e.Events {
+			sev = max(sev, ev.Severity())
+		}
+		return sev
+	}
+	return notifySeverities[e.Kind]
+}
+
+func (e NotifyEvent) Headline() string {
+	return e.template().Headline
+}
+
+// Detail renders the event body, passing the monitor name and IPs through
+// the given decorators (e.g. bold / code markup).
+func (e NotifyEvent) Detail(name, ip func(string) string) string {
+	return e.detail(plain, name, ip)
+}
+
+var placeholderRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// detail is Detail with the static template text escaped by esc, for
+// markup formats that reserve characters (Telegram MarkdownV2, HTML).
+func (e NotifyEvent) detail(esc, name, ip func(string) string) string {
+	t := e.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	values := map[string]string{
+		"monitor": name(e.Monitor),
+		"old_ip":  ip(e.OldIP),
+		"new_ip":  ip(e.NewIP),
+		"time":    esc(t.Format("2006-01-02 15:04:05")),
+		"actor":   esc(e.Actor),
+		"changes": esc(strings.Join(e.Changes, "\n")),
+	}
+	if len(e.Events) > 0 {
+		lines := make([]string, len(e.Events))
+		for i, ev := range e.Events {
+			lines[i] = ev.Time.Format("15:04") + " " + ev.Text()
+		}
+		values["count"] = esc(fmt.Sprint(len(e.Events)))
+		values["events"] = esc(strings.Join(lines, "\n"))
+	}
+
+	tmpl := e.template().Message
+	var sb strings.Builder
+	last := 0
+	for _, loc := range placeholderRe.FindAllStringSubmatchIndex(tmpl, -1) {
+		v, ok := values[tmpl[loc[2]:loc[3]]]
+		if !ok {
+			continue // Unknown placeholders stay as literal text
+		}
+		sb.WriteString(esc(tmpl[last:loc[0]]))
+		sb.WriteString(v)
+		last = loc[1]
+	}
+	sb.WriteString(esc(tmpl[last:]))
+	return sb.String()
+}
+
+func (e NotifyEvent) Text() string {
+	return e.Headline() + ": " + e.Detail(plain, plain)
+}
+
+func plain(s string) string { return s }
+
+// SendNotification delivers ev on every enabled channel, unless quiet hours
+// hold it for the next digest or it is part of a burst being batched.
+func SendNotification(ev NotifyEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if holdNotification(ev) || batchNotification(ev) {
+		return
+	}
+	dispatchNotification(ev)
+}
+
+// NotifyConfigChange reports a monitor created, updated or deleted through
+// the API, unless notification.config_changes is off.
+func NotifyConfigChange(kind string, m *Monitor, actor string, changes []string) {
+	if !GetConfig().Notification.ConfigChanges {
+		return
+	}
+	SendNotification(NotifyEvent{Kind: kind, Monitor: m.Name, Actor: actor, Changes: changes})
+}
+
+const defaultNotifyTitle = "CFGuard"
+
+// notifyTitle is notification.title_prefix, naming the instance in every
+// message.
+func notifyTitle() string {
+	if prefix := GetConfig().Notification.TitlePrefix; prefix != "" {
+		return prefix
+	}
+	return defaultNotifyTitle
+}
+
+// Channel names as used in notification.severities
+var notifyChannels = []string{"dingtalk", "telegram", "email", "gotify", "ntfy", "pushover", "teams", "matrix"}
+
+func validateChannelSeverities(filters map[string][]string) error {
+	for channel, severities := range filters {
+		if !slices.Contains(notifyChannels, channel) {
+			return fmt.Errorf("unknown channel %q, valid channels: %s", channel, strings.Join(notifyChannels, ", "))
+		}
+		for _, s := range severities {
+			if _, ok := severityNames[s]; !ok {
+				return fmt.Errorf("%s: unknown severity %q", channel, s)
+			}
+		}
+	}
+	return nil
+}
+
+// channelWants reports whether notification.severities lets channel
+// receive events of severity sev.
+func channelWants(filters map[string][]string, channel string, sev notifySeverity) bool {
+	severities, ok := filters[channel]
+	if !ok {
+		return true
+	}
+	for _, s := range severities {
+		if severityNames[s] == sev {
+			return true
+		}
+	}
+	return false
+}
+
+func dispatchNotification(ev NotifyEvent) {
+	message := ev.Text()
+	conf := GetConfig().Notification
+	sev := ev.Severity()
+	wants := func(channel string) bool { return channelWants(conf.Severities, channel, sev) }
+
 	// DingTalk
-	if AppConfig.Notification.DingTalk.Enabled {
-		go sendDingTalk(message)
+	if conf.DingTalk.Enabled && wants("dingtalk") {
+		go sendDingTalk(ev)
 	}
 
 	// Telegram
-	if AppConfig.Notification.Telegram.Enabled {
-		go sendTelegram(message)
+	if conf.Telegram.Enabled && wants("telegram") {
+		go sendTelegram(ev)
 	}
 
 	// Email
-	if AppConfig.Notification.Email.Enabled {
+	if conf.Email.Enabled && wants("email") {
 		go sendEmail(message)
 	}
+
+	// Gotify
+	if conf.Gotify.Enabled && wants("gotify") {
+		go sendGotify(ev)
+	}
+
+	// ntfy
+	if conf.Ntfy.Enabled && wants("ntfy") {
+		go sendNtfy(ev)
+	}
+
+	// Pushover
+	if conf.Pushover.Enabled && wants("pushover") {
+		go sendPushover(ev)
+	}
+
+	// Microsoft Teams
+	if conf.Teams.Enabled && wants("teams") {
+		go sendTeams(ev)
+	}
+
+	// Matrix
+	if conf.Matrix.Enabled && wants("matrix") {
+		go sendMatrix(ev)
+	}
 }
 
 var notifyClient = &http.Client{
 	Timeout: 10 * time.Second,
 }
 
-func sendDingTalk(content string) {
-	token := AppConfig.Notification.DingTalk.AccessToken
-	secret := AppConfig.Notification.DingTalk.Secret
+// hmacSHA256 signs data with secret, as used for DingTalk requests and
+// incoming webhook signatures.
+func hmacSHA256(secret string, data []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func sendDingTalk(ev NotifyEvent) {
+	conf := GetConfig().Notification.DingTalk
+	token := conf.AccessToken
+	secret := conf.Secret
 	if token == "" {
 		return
 	}
@@ -52,52 +353,124 @@ func sendDingTalk(content string) {
 	if secret != "" {
 		timestamp := time.Now().UnixNano() / 1e6
 		stringToSign := fmt.Sprintf("%d\n%s", timestamp, secret)
-		h := hmac.New(sha256.New, []byte(secret))
-		h.Write([]byte(stringToSign))
-		sign := base64.StdEncoding.EncodeToString(h.Sum(nil))
+		sign := base64.StdEncoding.EncodeToString(hmacSHA256(secret, []byte(stringToSign)))
 		// DingTalk signature needs URL encoding
 		apiUrl += fmt.Sprintf("&timestamp=%d&sign=%s", timestamp, url.QueryEscape(sign))
 	}
 
-	payload := map[string]interface{}{
-		"msgtype": "text",
-		"text": map[string]string{
-			"content": "CFGuard: " + content,
-		},
+	// Mentioned mobiles must also appear in the content to be highlighted
+	var mentions string
+	for _, mobile := range conf.At.Mobiles {
+		mentions += " @" + mobile
+	}
+
+	var payload map[string]interface{}
+	if conf.MsgType == "markdown" {
+		color := "#FF9900"
+		switch ev.Severity() {
+		case severityFailover:
+			color = "#FF0000"
+		case severityRecovery:
+			color = "#00A000"
+		}
+		bold := func(s string) string { return "**" + s + "**" }
+		text := fmt.Sprintf("### <font color=\"%s\">%s</font>\n\n%s", color, ev.Headline(), ev.Detail(bold, plain))
+		if mentions != "" {
+			text += "\n\n" + strings.TrimSpace(mentions)
+		}
+		payload = map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": notifyTitle() + ": " + ev.Headline(),
+				"text":  text,
+			},
+		}
+	} else {
+		payload = map[string]interface{}{
+			"msgtype": "text",
+			"text": map[string]string{
+				"content": notifyTitle() + ": " + ev.Text() + mentions,
+			},
+		}
+	}
+	payload["at"] = map[string]interface{}{
+		"atMobiles": conf.At.Mobiles,
+		"isAtAll":   conf.At.All,
 	}
 	jsonPayload, _ := json.Marshal(payload)
 
 	resp, err := notifyClient.Post(apiUrl, "application/json", bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		log.Printf("DingTalk notification failed: %v", err)
+		slog.Error("Notification failed", "channel", "dingtalk", "error", err)
 	} else {
 		defer resp.Body.Close()
 	}
 }
 
-func sendTelegram(content string) {
-	token := AppConfig.Notification.Telegram.BotToken
-	chatId := AppConfig.Notification.Telegram.ChatID
+// telegramMarkdownV2 escapes every character reserved by MarkdownV2
+var telegramMarkdownV2 = strings.NewReplacer(
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
+	"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!",
+	"\\", "\\\\",
+)
+
+func sendTelegram(ev NotifyEvent) {
+	conf := GetConfig().Notification.Telegram
+	token := conf.BotToken
+	chatId := conf.ChatID
 	if token == "" || chatId == "" {
 		return
 	}
+
+	title := notifyTitle()
+	var text string
+	switch conf.ParseMode {
+	case "MarkdownV2":
+		esc := telegramMarkdownV2.Replace
+		text = esc(title+": "+ev.Headline()+": ") + ev.detail(esc,
+			func(s string) string { return "*" + esc(s) + "*" },
+			// Inside code spans only ` and \ need escaping
+			func(s string) string {
+				return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(s) + "`"
+			},
+		)
+	case "HTML":
+		esc := html.EscapeString
+		text = esc(title+": "+ev.Headline()+": ") + ev.detail(esc,
+			func(s string) string { return "<b>" + esc(s) + "</b>" },
+			func(s string) string { return "<code>" + esc(s) + "</code>" },
+		)
+	default:
+		text = title + ": " + ev.Text()
+	}
+
 	apiUrl := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", token)
-	payload := map[string]string{
+	payload := map[string]interface{}{
 		"chat_id": chatId,
-		"text":    "CFGuard: " + content,
+		"text":    text,
+	}
+	if conf.ParseMode != "" {
+		payload["parse_mode"] = conf.ParseMode
+	}
+	if conf.MessageThreadID != 0 {
+		payload["message_thread_id"] = conf.MessageThreadID
+	}
+	if conf.DisableNotification && ev.Severity() != severityFailover {
+		payload["disable_notification"] = true
 	}
 	jsonPayload, _ := json.Marshal(payload)
 
 	resp, err := notifyClient.Post(apiUrl, "application/json", bytes.NewBuffer(jsonPayload))
 	if err != nil {
-		log.Printf("Telegram notification failed: %v", err)
+		slog.Error("Notification failed", "channel", "telegram", "error", err)
 	} else {
 		defer resp.Body.Close()
 	}
 }
 
 func sendEmail(content string) {
-	conf := AppConfig.Notification.Email
+	conf := GetConfig().Notification.Email
 	if !conf.Enabled {
 		return
 	}
@@ -105,7 +478,7 @@ func sendEmail(content string) {
 	addr := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
 
 	// Message Construction
-	subject := "CFGuard Notification"
+	subject := notifyTitle() + " Notification"
 	body := "To: " + conf.To + "\r\n" +
 		"Subject: " + subject + "\r\n" +
 		"Content-Type: text/plain; charset=UTF-8\r\n" +
@@ -128,50 +501,291 @@ func sendEmail(content string) {
 		dialer := &net.Dialer{Timeout: 10 * time.Second}
 		conn, tlsErr := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
 		if tlsErr != nil {
-			log.Println("Failed to dial TLS for email:", tlsErr)
+			slog.Error("Failed to dial TLS for email", "channel", "email", "error", tlsErr)
 			return
 		}
 
 		c, smtpErr := smtp.NewClient(conn, conf.Host)
 		if smtpErr != nil {
 			conn.Close()
-			log.Println("Failed to create SMTP client:", smtpErr)
+			slog.Error("Failed to create SMTP client", "channel", "email", "error", smtpErr)
 			return
 		}
 		defer c.Quit()
 
 		if err = c.Auth(auth); err != nil {
-			log.Println("SMTP Auth failed:", err)
+			slog.Error("SMTP Auth failed", "channel", "email", "error", err)
 			return
 		}
 		if err = c.Mail(conf.Username); err != nil {
-			log.Println("SMTP Mail failed:", err)
+			slog.Error("SMTP Mail failed", "channel", "email", "error", err)
 			return
 		}
 		if err = c.Rcpt(conf.To); err != nil {
-			log.Println("SMTP Rcpt failed:", err)
+			slog.Error("SMTP Rcpt failed", "channel", "email", "error", err)
 			return
 		}
 		w, err := c.Data()
 		if err != nil {
-			log.Println("SMTP Data failed:", err)
+			slog.Error("SMTP Data failed", "channel", "email", "error", err)
 			return
 		}
 		_, err = w.Write(msg)
 		if err != nil {
-			log.Println("SMTP Write failed:", err)
+			slog.Error("SMTP Write failed", "channel", "email", "error", err)
 			return
 		}
 		err = w.Close()
 		if err != nil {
-			log.Println("SMTP Close failed:", err)
+			slog.Error("SMTP Close failed", "channel", "email", "error", err)
 			return
 		}
 	} else {
 		// STARTTLS or Plain (587 or 25)
 		err = smtp.SendMail(addr, auth, conf.Username, []string{conf.To}, msg)
 		if err != nil {
-			log.Printf("Failed to send email: %v", err)
+			slog.Error("Failed to send email", "channel", "email", "error", err)
 		}
 	}
 }
+
+func sendGotify(ev NotifyEvent) {
+	content := ev.Text()
+	conf := GetConfig().Notification.Gotify
+	if conf.ServerURL == "" || conf.AppToken == "" {
+		return
+	}
+	apiUrl := strings.TrimRight(conf.ServerURL, "/") + "/message?token=" + url.QueryEscape(conf.AppToken)
+
+	// Gotify priorities: 0-3 silent/low, 4-7 normal, 8-10 high
+	priority := 5
+	switch ev.Severity() {
+	case severityFailover:
+		priority = 8
+	case severityRecovery:
+		priority = 6
+	}
+
+	payload := map[string]interface{}{
+		"title":    notifyTitle(),
+		"message":  content,
+		"priority": priority,
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	resp, err := notifyClient.Post(apiUrl, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		slog.Error("Notification failed", "channel", "gotify", "error", err)
+	} else {
+		defer resp.Body.Close()
+	}
+}
+
+func sendNtfy(ev NotifyEvent) {
+	content := ev.Text()
+	conf := GetConfig().Notification.Ntfy
+	if conf.Topic == "" {
+		return
+	}
+	server := conf.ServerURL
+	if server == "" {
+		server = "https://ntfy.sh"
+	}
+	apiUrl := strings.TrimRight(server, "/") + "/" + url.PathEscape(conf.Topic)
+
+	req, err := http.NewRequest("POST", apiUrl, strings.NewReader(content))
+	if err != nil {
+		slog.Error("Notification failed", "channel", "ntfy", "error", err)
+		return
+	}
+	req.Header.Set("Title", notifyTitle())
+	switch ev.Severity() {
+	case severityFailover:
+		req.Header.Set("Priority", "urgent")
+		req.Header.Set("Tags", "rotating_light")
+	case severityRecovery:
+		req.Header.Set("Priority", "default")
+		req.Header.Set("Tags", "white_check_mark")
+	default:
+		req.Header.Set("Priority", "default")
+		req.Header.Set("Tags", "information_source")
+	}
+	if conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+conf.Token)
+	}
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		slog.Error("Notification failed", "channel", "ntfy", "error", err)
+	} else {
+		defer resp.Body.Close()
+	}
+}
+
+func sendPushover(ev NotifyEvent) {
+	content := ev.Text()
+	conf := GetConfig().Notification.Pushover
+	if conf.Token == "" || conf.UserKey == "" {
+		return
+	}
+
+	priority := "0"
+	if ev.Severity() == severityFailover {
+		priority = "1"
+	}
+
+	form := url.Values{}
+	form.Set("token", conf.Token)
+	form.Set("user", conf.UserKey)
+	form.Set("title", notifyTitle())
+	form.Set("message", content)
+	form.Set("priority", priority)
+
+	resp, err := notifyClient.PostForm("https://api.pushover.net/1/messages.json", form)
+	if err != nil {
+		slog.Error("Notification failed", "channel", "pushover", "error", err)
+	} else {
+		defer resp.Body.Close()
+	}
+}
+
+// teamsFacts lists the monitor and IPs as card facts. Digests and batches
+// have none: their body already lists every event.
+func teamsFacts(ev NotifyEvent) [][2]string {
+	if len(ev.Events) > 0 || ev.Monitor == "" {
+		return nil
+	}
+	facts := [][2]string{{"Monitor", ev.Monitor}, {"Event", ev.Kind}}
+	if ev.OldIP != "" {
+		facts = append(facts, [2]string{"From", ev.OldIP})
+	}
+	if ev.NewIP != "" {
+		facts = append(facts, [2]string{"To", ev.NewIP})
+	}
+	return append(facts, [2]string{"Time", ev.Time.Format("2006-01-02 15:04:05")})
+}
+
+func sendTeams(ev NotifyEvent) {
+	conf := GetConfig().Notification.Teams
+	if conf.WebhookURL == "" {
+		return
+	}
+	title := notifyTitle() + ": " + ev.Headline()
+	// Teams renders the text as Markdown, which needs blank lines for breaks
+	text := strings.ReplaceAll(ev.Detail(plain, plain), "\n", "\n\n")
+	facts := teamsFacts(ev)
+
+	var payload map[string]interface{}
+	if conf.Format == "adaptive" {
+		color := "accent"
+		switch ev.Severity() {
+		case severityFailover:
+			color = "attention"
+		case severityRecovery:
+			color = "good"
+		}
+		body := []interface{}{
+			map[string]interface{}{"type": "TextBlock", "text": title, "weight": "bolder", "size": "medium", "color": color, "wrap": true},
+			map[string]interface{}{"type": "TextBlock", "text": text, "wrap": true},
+		}
+		if len(facts) > 0 {
+			factSet := make([]map[string]string, len(facts))
+			for i, f := range facts {
+				factSet[i] = map[string]string{"title": f[0], "value": f[1]}
+			}
+			body = append(body, map[string]interface{}{"type": "FactSet", "facts": factSet})
+		}
+		payload = map[string]interface{}{
+			"type": "message",
+			"attachments": []interface{}{map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]interface{}{
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"body":    body,
+				},
+			}},
+		}
+	} else {
+		color := "1976D2"
+		switch ev.Severity() {
+		case severityFailover:
+			color = "D32F2F"
+		case severityRecovery:
+			color = "2E7D32"
+		}
+		payload = map[string]interface{}{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": color,
+			"summary":    title,
+			"title":      title,
+			"text":       text,
+		}
+		if len(facts) > 0 {
+			sectionFacts := make([]map[string]string, len(facts))
+			for i, f := range facts {
+				sectionFacts[i] = map[string]string{"name": f[0], "value": f[1]}
+			}
+			payload["sections"] = []interface{}{map[string]interface{}{"facts": sectionFacts}}
+		}
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	resp, err := notifyClient.Post(conf.WebhookURL, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		slog.Error("Notification failed", "channel", "teams", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("Notification failed", "channel", "teams", "status", resp.StatusCode)
+	}
+}
+
+// Matrix transaction IDs must be unique per access token; the counter keeps
+// sends within the same nanosecond apart.
+var matrixTxnCounter atomic.Uint64
+
+func sendMatrix(ev NotifyEvent) {
+	conf := GetConfig().Notification.Matrix
+	if conf.HomeserverURL == "" || conf.AccessToken == "" || conf.RoomID == "" {
+		return
+	}
+
+	esc := html.EscapeString
+	formatted := "<b>" + esc(ev.Headline()) + "</b><br>" + strings.ReplaceAll(ev.detail(esc,
+		func(s string) string { return "<b>" + esc(s) + "</b>" },
+		func(s string) string { return "<code>" + esc(s) + "</code>" },
+	), "\n", "<br>")
+	payload := map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           notifyTitle() + ": " + ev.Text(),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	}
+	jsonPayload, _ := json.Marshal(payload)
+
+	txnID := fmt.Sprintf("cfguard-%d-%d", time.Now().UnixNano(), matrixTxnCounter.Add(1))
+	apiUrl := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(conf.HomeserverURL, "/"), url.PathEscape(conf.RoomID), txnID)
+
+	req, err := http.NewRequest("PUT", apiUrl, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		slog.Error("Notification failed", "channel", "matrix", "error", err)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		slog.Error("Notification failed", "channel", "matrix", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("Notification failed", "channel", "matrix", "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// setupTestDB points the app at a fresh database and a config with one
+// Cloudflare account named "default".
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Accounts = []AccountConfig{{Name: "default", ApiToken: "test-token"}}
+	cfg.Database.Path = t.TempDir() + "/cfguard.db"
+	SetConfig(&cfg)
+	InitDB()
+	t.Cleanup(func() {
+		if sqlDB, err := DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+}
+
+// rewriteTransport sends every request to the test server instead of the
+// host in its URL.
+type rewriteTransport struct{ target *url.URL }
+
+func (rt rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeCloudflare serves Cloudflare API calls from handler for the rest of
+// the test.
+func fakeCloudflare(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	target, _ := url.Parse(srv.URL)
+	orig := cfClient
+	cfClient = &http.Client{Transport: rewriteTransport{target}}
+	t.Cleanup(func() {
+		cfClient = orig
+		srv.Close()
+	})
+}
+
+// captureNotifications sends notifications in English to a fake ntfy
+// server. The returned func waits up to a second for at least n messages and
+// returns all received so far.
+func captureNotifications(t *testing.T) func(n int) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var messages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		messages = append(messages, string(body))
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	UpdateConfig(func(c *Config) {
+		c.Language = "en"
+		c.Notification.Ntfy.Enabled = true
+		c.Notification.Ntfy.ServerURL = srv.URL
+		c.Notification.Ntfy.Topic = "cfguard"
+	})
+	return func(n int) []string {
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+			mu.Lock()
+			got := slices.Clone(messages)
+			mu.Unlock()
+			if len(got) >= n || time.Now().After(deadline) {
+				return got
+			}
+		}
+	}
+}